@@ -0,0 +1,416 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/madhu72/mftkit/mft"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveDirectoryTar(t *testing.T) {
+	utils := mft.NewMFT()
+	sourceDir := t.TempDir()
+
+	os.MkdirAll(filepath.Join(sourceDir, "reports", "2024"), os.ModePerm)
+	writeTestFile(t, filepath.Join(sourceDir, "readme.txt"), 100)
+	writeTestFile(t, filepath.Join(sourceDir, "reports", "2024", "q1.csv"), 5000)
+	os.WriteFile(filepath.Join(sourceDir, "run.sh"), []byte("#!/bin/sh\n"), 0755)
+	os.Symlink(filepath.Join("reports", "2024", "q1.csv"), filepath.Join(sourceDir, "latest.csv"))
+
+	modTime := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(sourceDir, "readme.txt"), modTime, modTime)
+	os.Chtimes(filepath.Join(sourceDir, "reports"), modTime, modTime)
+
+	for _, compression := range []string{"", "gzip", "zstd"} {
+		archive := filepath.Join(t.TempDir(), "tree.tar")
+		if err := utils.ArchiveDirectory(sourceDir, archive, compression); err != nil {
+			t.Fatalf("Error archiving with %q: %v", compression, err)
+		}
+
+		destination := t.TempDir()
+		options := mft.ExtractOptions{RestoreMetadata: true}
+		if err := utils.ExtractArchive(archive, destination, options); err != nil {
+			t.Fatalf("Error extracting %q archive: %v", compression, err)
+		}
+
+		assertSameChecksum(t, utils, filepath.Join(sourceDir, "reports", "2024", "q1.csv"), filepath.Join(destination, "reports", "2024", "q1.csv"))
+		if link, err := os.Readlink(filepath.Join(destination, "latest.csv")); err != nil || link != filepath.Join("reports", "2024", "q1.csv") {
+			t.Errorf("Expected the symlink to be restored, got %q (%v)", link, err)
+		}
+		if info, err := os.Stat(filepath.Join(destination, "run.sh")); err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("Expected mode 0755 for run.sh, got: %v (%v)", info.Mode(), err)
+		}
+		for _, name := range []string{"readme.txt", "reports"} {
+			if info, err := os.Stat(filepath.Join(destination, name)); err != nil || !info.ModTime().Equal(modTime) {
+				t.Errorf("Expected %s to keep its modification time, got: %v (%v)", name, info.ModTime(), err)
+			}
+		}
+	}
+}
+
+func TestExtractArchiveGlobalHeader(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+
+	// git archive starts every tarball with a global pax header.
+	tarPath := filepath.Join(dir, "git.tar")
+	file, _ := os.Create(tarPath)
+	writer := tar.NewWriter(file)
+	writer.WriteHeader(&tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "e41ca71"}})
+	writer.WriteHeader(&tar.Header{Name: "project/README", Mode: 0644, Size: 5, Typeflag: tar.TypeReg})
+	writer.Write([]byte("hello"))
+	writer.Close()
+	file.Close()
+
+	destination := t.TempDir()
+	if err := utils.ExtractArchive(tarPath, destination, mft.ExtractOptions{}); err != nil {
+		t.Fatalf("Error extracting archive with a global header: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(destination, "project", "README")); err != nil || string(data) != "hello" {
+		t.Errorf("Expected project/README, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(destination, "pax_global_header")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file for the global header")
+	}
+}
+
+func TestExtractArchiveOwners(t *testing.T) {
+	utils := mft.NewMFT()
+	sourceDir := t.TempDir()
+	writeTestFile(t, filepath.Join(sourceDir, "owned.txt"), 10)
+
+	archive := filepath.Join(t.TempDir(), "owned.tar.gz")
+	if err := utils.ArchiveDirectory(sourceDir, archive, "gzip"); err != nil {
+		t.Fatalf("Error archiving: %v", err)
+	}
+
+	destination := t.TempDir()
+	options := mft.ExtractOptions{RestoreMetadata: true, RestoreOwners: true}
+	if err := utils.ExtractArchive(archive, destination, options); err != nil {
+		t.Fatalf("Error extracting with owners: %v", err)
+	}
+	owner, err := utils.GetFileOwner(filepath.Join(destination, "owned.txt"))
+	expected, _ := utils.GetFileOwner(filepath.Join(sourceDir, "owned.txt"))
+	if err != nil || owner != expected {
+		t.Errorf("Expected owner %q, got %q (%v)", expected, owner, err)
+	}
+}
+
+type testEntry struct {
+	name     string
+	linkname string
+	data     []byte
+}
+
+func writeTestZip(t *testing.T, path string, entries []testEntry) {
+	t.Helper()
+	file, _ := os.Create(path)
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		data := entry.data
+		if entry.linkname != "" {
+			header.SetMode(os.ModeSymlink | 0777)
+			data = []byte(entry.linkname)
+		}
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("Error writing zip entry: %v", err)
+		}
+		w.Write(data)
+	}
+	writer.Close()
+}
+
+func writeTestTarGz(t *testing.T, path string, entries []testEntry) {
+	t.Helper()
+	file, _ := os.Create(path)
+	defer file.Close()
+	compressor := gzip.NewWriter(file)
+	writer := tar.NewWriter(compressor)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data)), Typeflag: tar.TypeReg}
+		if entry.linkname != "" {
+			header = &tar.Header{Name: entry.name, Mode: 0777, Linkname: entry.linkname, Typeflag: tar.TypeSymlink}
+		}
+		if strings.HasSuffix(entry.name, "/") {
+			header = &tar.Header{Name: entry.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		writer.WriteHeader(header)
+		writer.Write(entry.data)
+	}
+	writer.Close()
+	compressor.Close()
+}
+
+func TestSafeExtraction(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	small := []byte("payload")
+	zeros := make([]byte, 8<<20)
+
+	cases := []struct {
+		name    string
+		entries []testEntry
+		options mft.ExtractOptions
+		want    error
+	}{
+		{"zip slip", []testEntry{{name: "../../evil.txt", data: small}}, mft.ExtractOptions{}, mft.ErrUnsafePath},
+		{"absolute path", []testEntry{{name: "/etc/cron.d/evil", data: small}}, mft.ExtractOptions{}, mft.ErrAbsolutePath},
+		{"symlink escape", []testEntry{{name: "etc", linkname: "../../etc"}}, mft.ExtractOptions{}, mft.ErrUnsafeSymlink},
+		{"chained symlink escape", []testEntry{{name: "sub/sub2/l", linkname: "../.."}, {name: "x", linkname: "sub/sub2/l/../.."}}, mft.ExtractOptions{}, mft.ErrUnsafeSymlink},
+		{"absolute symlink", []testEntry{{name: "etc", linkname: "/etc"}}, mft.ExtractOptions{}, mft.ErrUnsafeSymlink},
+		{"write through symlink", []testEntry{{name: "sub/a.txt", data: small}, {name: "link", linkname: "sub"}, {name: "link/b.txt", data: small}}, mft.ExtractOptions{}, mft.ErrUnsafeSymlink},
+		{"refused symlink", []testEntry{{name: "a.txt", data: small}, {name: "link", linkname: "a.txt"}}, mft.ExtractOptions{Symlinks: mft.SymlinksRefuse}, mft.ErrUnsafeSymlink},
+		{"too many entries", []testEntry{{name: "a", data: small}, {name: "b", data: small}, {name: "c", data: small}}, mft.ExtractOptions{MaxEntries: 2}, mft.ErrTooManyEntries},
+		{"too large", []testEntry{{name: "big.bin", data: bytes.Repeat([]byte("abcdefgh"), 1000)}}, mft.ExtractOptions{MaxTotalSize: 4000}, mft.ErrArchiveTooLarge},
+		{"compression bomb", []testEntry{{name: "zeros.bin", data: zeros}}, mft.ExtractOptions{}, mft.ErrCompressionRatio},
+	}
+
+	for _, tc := range cases {
+		zipPath := filepath.Join(dir, "case.zip")
+		writeTestZip(t, zipPath, tc.entries)
+		tarPath := filepath.Join(dir, "case.tar.gz")
+		writeTestTarGz(t, tarPath, tc.entries)
+
+		for format, extract := range map[string]func(string, string, mft.ExtractOptions) error{
+			zipPath: utils.UnarchiveFileWithOptions,
+			tarPath: utils.ExtractArchive,
+		} {
+			destination := filepath.Join(t.TempDir(), "out")
+			err := extract(format, destination, tc.options)
+			var archiveErr *mft.ArchiveError
+			if !errors.Is(err, tc.want) || !errors.As(err, &archiveErr) {
+				t.Errorf("%s (%s): expected %v, got: %v", tc.name, filepath.Base(format), tc.want, err)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written outside the destination")
+	}
+
+	// Confined symlinks and ordinary content still extract.
+	entries := []testEntry{{name: "data/a.txt", data: small}, {name: "current", linkname: "data/a.txt"}}
+	zipPath := filepath.Join(dir, "good.zip")
+	writeTestZip(t, zipPath, entries)
+	destination := t.TempDir()
+	if err := utils.UnarchiveFile(zipPath, destination); err != nil {
+		t.Fatalf("Error extracting safe zip: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(destination, "current")); err != nil || !bytes.Equal(data, small) {
+		t.Errorf("Expected the confined symlink to resolve, got %q (%v)", data, err)
+	}
+}
+
+func TestExtractIntoSymlinkedDestination(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	realDir := filepath.Join(dir, "real")
+	os.Mkdir(realDir, 0755)
+	linkDir := filepath.Join(dir, "link")
+	os.Symlink(realDir, linkDir)
+
+	// Archives made with tar -C dir -czf x.tgz . start with a ./ entry.
+	entries := []testEntry{{name: "./"}, {name: "./b.txt", data: []byte("payload")}}
+	tarPath := filepath.Join(dir, "dot.tar.gz")
+	writeTestTarGz(t, tarPath, entries)
+	zipPath := filepath.Join(dir, "dot.zip")
+	writeTestZip(t, zipPath, entries)
+
+	for archivePath, extract := range map[string]func(string, string, mft.ExtractOptions) error{
+		tarPath: utils.ExtractArchive,
+		zipPath: utils.UnarchiveFileWithOptions,
+	} {
+		os.Remove(filepath.Join(realDir, "b.txt"))
+		if err := extract(archivePath, linkDir, mft.ExtractOptions{}); err != nil {
+			t.Fatalf("Error extracting %s: %v", filepath.Base(archivePath), err)
+		}
+		if info, err := os.Lstat(linkDir); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Expected the destination symlink to be kept (%s)", filepath.Base(archivePath))
+		}
+		if data, err := os.ReadFile(filepath.Join(realDir, "b.txt")); err != nil || string(data) != "payload" {
+			t.Errorf("Expected b.txt in the real directory (%s), got %q (%v)", filepath.Base(archivePath), data, err)
+		}
+	}
+}
+
+func TestArchiveBuilders(t *testing.T) {
+	utils := mft.NewMFT()
+	sourceDir := t.TempDir()
+	os.MkdirAll(filepath.Join(sourceDir, "reports", "tmp"), os.ModePerm)
+	os.MkdirAll(filepath.Join(sourceDir, "logs"), os.ModePerm)
+	writeTestFile(t, filepath.Join(sourceDir, "reports", "q1.csv"), 100)
+	writeTestFile(t, filepath.Join(sourceDir, "reports", "q2.csv"), 100)
+	writeTestFile(t, filepath.Join(sourceDir, "reports", "notes.txt"), 100)
+	writeTestFile(t, filepath.Join(sourceDir, "reports", "tmp", "q3.csv"), 100)
+	writeTestFile(t, filepath.Join(sourceDir, "logs", "a.log"), 100)
+	writeTestFile(t, filepath.Join(sourceDir, "logs", "b.log"), 100)
+	writeTestFile(t, filepath.Join(sourceDir, "single.txt"), 100)
+
+	options := mft.ArchiveBuilderOptions{
+		Include: []string{"*.csv", "*.log"},
+		Exclude: []string{"tmp"},
+		Rename:  func(name string) string { return "export/" + name },
+	}
+
+	var zipBuffer bytes.Buffer
+	zipBuilder := utils.NewZipBuilder(&zipBuffer, options)
+	if err := zipBuilder.AddDirectory(filepath.Join(sourceDir, "reports"), "reports"); err != nil {
+		t.Fatalf("Error adding directory: %v", err)
+	}
+	if err := zipBuilder.AddGlob(filepath.Join(sourceDir, "logs", "*.log")); err != nil {
+		t.Fatalf("Error adding glob: %v", err)
+	}
+	if err := zipBuilder.AddFile(filepath.Join(sourceDir, "single.txt"), "extra/single.csv"); err != nil {
+		t.Fatalf("Error adding file: %v", err)
+	}
+	if err := zipBuilder.Close(); err != nil {
+		t.Fatalf("Error closing zip builder: %v", err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(zipBuffer.Bytes()), int64(zipBuffer.Len()))
+	if err != nil {
+		t.Fatalf("Error reading zip: %v", err)
+	}
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	expected := []string{"export/reports/q1.csv", "export/reports/q2.csv", "export/a.log", "export/b.log", "export/extra/single.csv"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected entries %v, got: %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected entry %s, got: %s", expected[i], names[i])
+		}
+	}
+
+	var tarBuffer bytes.Buffer
+	tarBuilder, err := utils.NewTarBuilder(&tarBuffer, mft.ArchiveBuilderOptions{Compression: "gzip", Exclude: []string{"*.log"}})
+	if err != nil {
+		t.Fatalf("Error creating tar builder: %v", err)
+	}
+	if err := tarBuilder.AddDirectory(sourceDir, ""); err != nil {
+		t.Fatalf("Error adding directory: %v", err)
+	}
+	if err := tarBuilder.Close(); err != nil {
+		t.Fatalf("Error closing tar builder: %v", err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "tree.tar.gz")
+	os.WriteFile(archive, tarBuffer.Bytes(), 0644)
+	destination := t.TempDir()
+	if err := utils.ExtractArchive(archive, destination, mft.ExtractOptions{}); err != nil {
+		t.Fatalf("Error extracting streamed tar: %v", err)
+	}
+	if !utils.CheckFileExists(filepath.Join(destination, "reports", "tmp", "q3.csv")) {
+		t.Errorf("Expected reports/tmp/q3.csv in the tar")
+	}
+	if utils.CheckFileExists(filepath.Join(destination, "logs", "a.log")) {
+		t.Errorf("Expected logs to be excluded from the tar")
+	}
+}
+
+func TestArchiveFilesFollowsSymlinks(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	data := writeTestFile(t, filepath.Join(dir, "target.csv"), 1000)
+	linkPath := filepath.Join(dir, "latest.csv")
+	os.Symlink("target.csv", linkPath)
+
+	zipPath := filepath.Join(dir, "files.zip")
+	if err := utils.ArchiveFiles([]string{linkPath}, zipPath); err != nil {
+		t.Fatalf("Error archiving files: %v", err)
+	}
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatalf("Error opening zip: %v", err)
+	}
+	defer reader.Close()
+	if len(reader.File) != 1 || reader.File[0].Name != "latest.csv" || !reader.File[0].Mode().IsRegular() {
+		t.Fatalf("Expected latest.csv as a regular file, got: %v", reader.File)
+	}
+	file, err := reader.File[0].Open()
+	if err != nil {
+		t.Fatalf("Error opening entry: %v", err)
+	}
+	defer file.Close()
+	var contents bytes.Buffer
+	contents.ReadFrom(file)
+	if !bytes.Equal(contents.Bytes(), data) {
+		t.Errorf("Expected the symlink's target contents to be archived")
+	}
+}
+
+func TestEncryptedZip(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	first := writeTestFile(t, filepath.Join(dir, "first.txt"), 100000)
+	second := writeTestFile(t, filepath.Join(dir, "second.txt"), 10)
+	archivePath := filepath.Join(dir, "secret.zip")
+	files := []string{filepath.Join(dir, "first.txt"), filepath.Join(dir, "second.txt")}
+	if err := utils.ArchiveFilesWithPassword(files, archivePath, "s3cret"); err != nil {
+		t.Fatalf("Error creating encrypted zip: %v", err)
+	}
+
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatalf("Error opening zip: %v", err)
+	}
+	for _, file := range reader.File {
+		if file.Method != 99 || file.Flags&0x1 == 0 {
+			t.Errorf("Expected %s to be AES encrypted, got method %d flags %#x", file.Name, file.Method, file.Flags)
+		}
+	}
+	dataOffset, _ := reader.File[0].DataOffset()
+	reader.Close()
+
+	destination := filepath.Join(dir, "out")
+	if err := utils.UnarchiveFileWithPassword(archivePath, destination, "s3cret"); err != nil {
+		t.Fatalf("Error extracting encrypted zip: %v", err)
+	}
+	for name, expected := range map[string][]byte{"first.txt": first, "second.txt": second} {
+		data, err := os.ReadFile(filepath.Join(destination, name))
+		if err != nil || !bytes.Equal(data, expected) {
+			t.Errorf("Expected %s to round trip, got error: %v", name, err)
+		}
+	}
+
+	err = utils.UnarchiveFileWithPassword(archivePath, filepath.Join(dir, "wrong"), "guess")
+	if !errors.Is(err, mft.ErrZipWrongPassword) {
+		t.Errorf("Expected ErrZipWrongPassword, got: %v", err)
+	}
+	var archiveErr *mft.ArchiveError
+	if !errors.As(err, &archiveErr) || archiveErr.Entry != "first.txt" {
+		t.Errorf("Expected an ArchiveError for first.txt, got: %v", err)
+	}
+	if err := utils.UnarchiveFile(archivePath, filepath.Join(dir, "none")); !errors.Is(err, mft.ErrZipPasswordRequired) {
+		t.Errorf("Expected ErrZipPasswordRequired, got: %v", err)
+	}
+
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("Error reading zip: %v", err)
+	}
+	// Skip the salt and password verifier and damage the ciphertext.
+	data[dataOffset+100] ^= 0xff
+	corruptPath := filepath.Join(dir, "corrupt.zip")
+	os.WriteFile(corruptPath, data, 0644)
+	corruptDestination := filepath.Join(dir, "corrupt")
+	if err := utils.UnarchiveFileWithPassword(corruptPath, corruptDestination, "s3cret"); !errors.Is(err, mft.ErrZipCorrupt) {
+		t.Errorf("Expected ErrZipCorrupt, got: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(corruptDestination, "first.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no output for a corrupt entry, got: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"github.com/madhu72/mftkit/mft"
+	"golang.org/x/crypto/blake2b"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChecksumManifest(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), os.ModePerm)
+	writeTestFile(t, filepath.Join(dir, "a.txt"), 100)
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), 200)
+	writeTestFile(t, filepath.Join(dir, "sub", "c.txt"), 300)
+
+	manifestPath := filepath.Join(dir, "SHA256SUMS")
+	entries, err := utils.GenerateChecksumManifest(dir, manifestPath, mft.ChecksumOptions{Workers: 2})
+	if err != nil {
+		t.Fatalf("Error generating manifest: %v", err)
+	}
+	if len(entries) != 3 || entries[1].Path != "sub/b.txt" {
+		t.Fatalf("Expected 3 sorted entries, got: %+v", entries)
+	}
+	data, _ := os.ReadFile(manifestPath)
+	if !strings.HasPrefix(string(data), entries[0].Hash+"  a.txt\n") {
+		t.Errorf("Expected GNU lines, got: %q", data)
+	}
+	if _, err := utils.VerifyChecksumManifest(manifestPath, mft.ChecksumOptions{}); err != nil {
+		t.Errorf("Error verifying manifest: %v", err)
+	}
+
+	bsdPath := filepath.Join(dir, "checksums.bsd")
+	if _, err := utils.GenerateChecksumManifest(dir, bsdPath, mft.ChecksumOptions{Format: mft.ChecksumBSD, Algorithm: "sha512"}); err != nil {
+		t.Fatalf("Error generating BSD manifest: %v", err)
+	}
+	data, _ = os.ReadFile(bsdPath)
+	if !strings.HasPrefix(string(data), "SHA512 (SHA256SUMS) = ") {
+		t.Errorf("Expected BSD lines, got: %q", data)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), 201)
+	os.Remove(filepath.Join(dir, "sub", "c.txt"))
+	results, err := utils.VerifyChecksumManifest(manifestPath, mft.ChecksumOptions{})
+	if !errors.Is(err, mft.ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got: %v", err)
+	}
+	var statuses []string
+	for _, result := range results {
+		statuses = append(statuses, result.Path+" "+result.Status.String())
+	}
+	if strings.Join(statuses, ", ") != "a.txt OK, sub/b.txt FAILED, sub/c.txt MISSING" {
+		t.Errorf("Expected OK, FAILED and MISSING, got: %v", statuses)
+	}
+}
+
+func TestChecksumSidecars(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "report.csv")
+	data := writeTestFile(t, filePath, 1000)
+
+	sidecarPath, err := utils.WriteChecksumSidecar(filePath, mft.ChecksumOptions{})
+	if err != nil || sidecarPath != filePath+".sha256" {
+		t.Fatalf("Expected a .sha256 sidecar, got: %v, %v", sidecarPath, err)
+	}
+	if _, err := utils.VerifyChecksumManifest(sidecarPath, mft.ChecksumOptions{}); err != nil {
+		t.Errorf("Error verifying sidecar: %v", err)
+	}
+
+	// A partner's md5sum sidecar in binary mode, recognised by its name.
+	sum := md5.Sum(data)
+	os.WriteFile(filePath+".md5", []byte(hex.EncodeToString(sum[:])+" *report.csv\n"), 0644)
+	results, err := utils.VerifyChecksumManifest(filePath+".md5", mft.ChecksumOptions{})
+	if err != nil || len(results) != 1 || results[0].Algorithm != "md5" || results[0].Status != mft.ChecksumOK {
+		t.Errorf("Expected the md5 sidecar to verify, got: %+v, %v", results, err)
+	}
+}
+
+func TestHashRegistry(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	emptyPath := filepath.Join(dir, "empty")
+	os.WriteFile(emptyPath, nil, 0644)
+
+	sums, err := utils.HashFile(emptyPath, "sha3-256", "blake3", "xxh64", "blake2b-256")
+	if err != nil {
+		t.Fatalf("Error hashing file: %v", err)
+	}
+	expected := map[string]string{
+		"sha3-256":    "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a",
+		"blake3":      "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+		"xxh64":       "ef46db3751d8e999",
+		"blake2b-256": "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8",
+	}
+	for algorithm, sum := range expected {
+		if sums[algorithm] != sum {
+			t.Errorf("Expected %s digest %s, got: %s", algorithm, sum, sums[algorithm])
+		}
+	}
+
+	crcPath := filepath.Join(dir, "digits")
+	os.WriteFile(crcPath, []byte("123456789"), 0644)
+	if valid, err := utils.VerifyDataIntegrity(crcPath, "crc32c", "e3069283"); err != nil || !valid {
+		t.Errorf("Expected the CRC32C check value, got: %v, %v", valid, err)
+	}
+	if _, err := utils.NewHash("whirlpool"); err == nil {
+		t.Errorf("Expected an error for an unknown hash")
+	}
+	if err := utils.RegisterHash("md5-twice", md5.New); err != nil {
+		t.Fatalf("Error registering hash: %v", err)
+	}
+	if _, err := utils.HashFile(crcPath, "md5-twice"); err != nil {
+		t.Errorf("Expected the registered hash to be usable, got: %v", err)
+	}
+
+	manifests, err := utils.GenerateChecksumManifests(dir, map[string]string{
+		"sha256": filepath.Join(dir, "SHA256SUMS"),
+		"md5":    filepath.Join(dir, "MD5SUMS"),
+	}, mft.ChecksumOptions{})
+	if err != nil {
+		t.Fatalf("Error generating manifests: %v", err)
+	}
+	if len(manifests["md5"]) != 2 || len(manifests["sha256"]) != 2 {
+		t.Errorf("Expected both manifests to list 2 files, got: %+v", manifests)
+	}
+	for _, name := range []string{"SHA256SUMS", "MD5SUMS"} {
+		if _, err := utils.VerifyChecksumManifest(filepath.Join(dir, name), mft.ChecksumOptions{}); err != nil {
+			t.Errorf("Error verifying %s: %v", name, err)
+		}
+	}
+}
+
+func TestChecksumB2Sums(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	data := writeTestFile(t, filepath.Join(dir, "report.csv"), 1000)
+
+	// b2sum writes untagged BLAKE2b-512 lines, usually to a file named B2SUMS.
+	sum := blake2b.Sum512(data)
+	line := []byte(hex.EncodeToString(sum[:]) + "  report.csv\n")
+	os.WriteFile(filepath.Join(dir, "B2SUMS"), line, 0644)
+	results, err := utils.VerifyChecksumManifest(filepath.Join(dir, "B2SUMS"), mft.ChecksumOptions{})
+	if err != nil || len(results) != 1 || results[0].Algorithm != "blake2b-512" || results[0].Status != mft.ChecksumOK {
+		t.Errorf("Expected the B2SUMS manifest to verify, got: %+v, %v", results, err)
+	}
+
+	// Without a telling name, 128 hex digits could also be sha512 or sha3-512.
+	os.WriteFile(filepath.Join(dir, "checksums.txt"), line, 0644)
+	if _, err := utils.VerifyChecksumManifest(filepath.Join(dir, "checksums.txt"), mft.ChecksumOptions{}); !errors.Is(err, mft.ErrAmbiguousChecksum) {
+		t.Errorf("Expected ErrAmbiguousChecksum, got: %v", err)
+	}
+	results, err = utils.VerifyChecksumManifest(filepath.Join(dir, "checksums.txt"), mft.ChecksumOptions{Algorithm: "blake2b-512"})
+	if err != nil || len(results) != 1 || results[0].Status != mft.ChecksumOK {
+		t.Errorf("Expected an explicit algorithm to verify, got: %+v, %v", results, err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/madhu72/mftkit/mft"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressionCodecs(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "report.csv")
+	os.WriteFile(source, bytes.Repeat([]byte("id,amount,currency\n42,1000.00,EUR\n"), 5000), 0644)
+
+	for _, codec := range []string{"gzip", "zstd", "xz", "bzip2", "zlib"} {
+		for _, level := range []int{mft.DefaultCompression, mft.BestSpeed, mft.BestCompression} {
+			compressed := filepath.Join(dir, "report.csv."+codec)
+			if err := utils.CompressFileWith(source, compressed, codec, level); err != nil {
+				t.Fatalf("Error compressing with %s level %d: %v", codec, level, err)
+			}
+			decompressed := filepath.Join(dir, "decompressed.csv")
+			if err := utils.DecompressFile(compressed, decompressed); err != nil {
+				t.Fatalf("Error decompressing %s level %d: %v", codec, level, err)
+			}
+			assertSameChecksum(t, utils, source, decompressed)
+		}
+	}
+
+	if err := utils.DecompressFile(source, filepath.Join(dir, "out")); !errors.Is(err, mft.ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat for plain text, got: %v", err)
+	}
+}
+
+func TestCompressionStreams(t *testing.T) {
+	utils := mft.NewMFT()
+	data := bytes.Repeat([]byte("streaming payload "), 10000)
+
+	var compressed bytes.Buffer
+	writer, err := utils.NewCompressWriter(&compressed, "zstd", 3)
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	writer.Write(data)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Error closing writer: %v", err)
+	}
+
+	reader, err := utils.NewDecompressReader(&compressed)
+	if err != nil {
+		t.Fatalf("Error creating reader: %v", err)
+	}
+	defer reader.Close()
+	result, err := io.ReadAll(reader)
+	if err != nil || !bytes.Equal(result, data) {
+		t.Errorf("Decompressed stream does not match the original (%v)", err)
+	}
+
+	if _, err := utils.NewCompressWriter(io.Discard, "lz4", 0); err == nil {
+		t.Errorf("Expected an error for an unknown codec")
+	}
+}
+
+func TestCompressFileParallel(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "extract.dat")
+	os.WriteFile(source, bytes.Repeat([]byte("0123456789abcdef record\n"), 40000), 0644)
+
+	for _, codec := range []string{"gzip", "zstd"} {
+		var updates []mft.CompressionProgress
+		compressed := filepath.Join(dir, "extract.dat."+codec)
+		options := mft.ParallelCompressOptions{
+			Codec:     codec,
+			BlockSize: 64 << 10,
+			Workers:   4,
+			Progress: func(progress mft.CompressionProgress) {
+				updates = append(updates, progress)
+			},
+		}
+		if err := utils.CompressFileParallel(source, compressed, options); err != nil {
+			t.Fatalf("Error compressing with %s: %v", codec, err)
+		}
+
+		decompressed := filepath.Join(dir, "decompressed.dat")
+		if err := utils.DecompressFile(compressed, decompressed); err != nil {
+			t.Fatalf("Error decompressing %s: %v", codec, err)
+		}
+		assertSameChecksum(t, utils, source, decompressed)
+
+		if len(updates) != 15 {
+			t.Errorf("Expected 15 progress updates for %s, got: %d", codec, len(updates))
+		}
+		last := updates[len(updates)-1]
+		if last.BytesRead != last.TotalBytes || last.BytesWritten == 0 {
+			t.Errorf("Unexpected final progress for %s: %+v", codec, last)
+		}
+	}
+
+	empty := filepath.Join(dir, "empty.dat")
+	os.WriteFile(empty, nil, 0644)
+	if err := utils.CompressFileParallel(empty, empty+".gz", mft.ParallelCompressOptions{}); err != nil {
+		t.Fatalf("Error compressing an empty file: %v", err)
+	}
+	if err := utils.DecompressFile(empty+".gz", filepath.Join(dir, "empty.out")); err != nil {
+		t.Errorf("Error decompressing an empty file: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"github.com/madhu72/mftkit/mft"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKey = "0123456789abcdef0123456789abcdef"
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+
+	for _, size := range []int{0, 1, 64 << 10, 64<<10 + 1, 300 << 10} {
+		source := filepath.Join(dir, "plain.bin")
+		data := writeTestFile(t, source, size)
+		encrypted := filepath.Join(dir, "encrypted.bin")
+		decrypted := filepath.Join(dir, "decrypted.bin")
+
+		if err := utils.EncryptFile(source, encrypted, testKey); err != nil {
+			t.Fatalf("Error encrypting %d bytes: %v", size, err)
+		}
+		if err := utils.DecryptFile(encrypted, decrypted, testKey); err != nil {
+			t.Fatalf("Error decrypting %d bytes: %v", size, err)
+		}
+		result, _ := os.ReadFile(decrypted)
+		if !bytes.Equal(result, data) {
+			t.Errorf("Decrypted %d bytes do not match the original", size)
+		}
+	}
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "plain.bin")
+	writeTestFile(t, source, 200<<10)
+	encrypted := filepath.Join(dir, "encrypted.bin")
+	if err := utils.EncryptFile(source, encrypted, testKey); err != nil {
+		t.Fatalf("Error encrypting: %v", err)
+	}
+	ciphertext, _ := os.ReadFile(encrypted)
+
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[len(tampered)/2] ^= 0x01
+	os.WriteFile(encrypted, tampered, 0644)
+	decrypted := filepath.Join(dir, "decrypted.bin")
+	if err := utils.DecryptFile(encrypted, decrypted, testKey); !errors.Is(err, mft.ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for tampered data, got: %v", err)
+	}
+	if utils.CheckFileExists(decrypted) {
+		t.Errorf("Expected no output for tampered data")
+	}
+
+	// Cut the stream at the end of the first chunk (header plus 64 KiB and a tag).
+	headerSize := 4 + 15
+	os.WriteFile(encrypted, ciphertext[:headerSize+64<<10+16], 0644)
+	if err := utils.DecryptFile(encrypted, decrypted, testKey); !errors.Is(err, mft.ErrTruncatedCiphertext) {
+		t.Errorf("Expected ErrTruncatedCiphertext, got: %v", err)
+	}
+
+	os.WriteFile(encrypted, ciphertext, 0644)
+	if err := utils.DecryptFile(encrypted, decrypted, "fedcba9876543210fedcba9876543210"); !errors.Is(err, mft.ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for a wrong key, got: %v", err)
+	}
+}
+
+func TestDecryptLegacyCFB(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+
+	plaintext := []byte("legacy partner payload")
+	block, _ := aes.NewCipher([]byte(testKey))
+	iv := make([]byte, aes.BlockSize)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(ciphertext, plaintext)
+
+	legacy := filepath.Join(dir, "legacy.bin")
+	os.WriteFile(legacy, append(iv, ciphertext...), 0644)
+	decrypted := filepath.Join(dir, "decrypted.txt")
+
+	if err := utils.DecryptFile(legacy, decrypted, testKey); !errors.Is(err, mft.ErrLegacyCiphertext) {
+		t.Errorf("Expected ErrLegacyCiphertext without the option, got: %v", err)
+	}
+
+	err := utils.DecryptFileWithOptions(legacy, decrypted, testKey, mft.DecryptOptions{AllowLegacyCFB: true})
+	if err != nil {
+		t.Fatalf("Error decrypting legacy file: %v", err)
+	}
+	result, _ := os.ReadFile(decrypted)
+	if !bytes.Equal(result, plaintext) {
+		t.Errorf("Expected %q, got %q", plaintext, result)
+	}
+}
+
+func TestEncryptStream(t *testing.T) {
+	utils := mft.NewMFT()
+	data := bytes.Repeat([]byte("stream"), 50000)
+
+	var encrypted, decrypted bytes.Buffer
+	if err := utils.EncryptStream(&encrypted, bytes.NewReader(data), []byte(testKey)); err != nil {
+		t.Fatalf("Error encrypting stream: %v", err)
+	}
+	if err := utils.DecryptStream(&decrypted, &encrypted, []byte(testKey)); err != nil {
+		t.Fatalf("Error decrypting stream: %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), data) {
+		t.Errorf("Decrypted stream does not match the original")
+	}
+}
+
+func TestPassphraseEncryption(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "plain.bin")
+	writeTestFile(t, source, 100<<10)
+	encrypted := filepath.Join(dir, "encrypted.bin")
+	decrypted := filepath.Join(dir, "decrypted.bin")
+
+	if err := utils.EncryptFileWithPassphrase(source, encrypted, "correct horse battery staple"); err != nil {
+		t.Fatalf("Error encrypting with passphrase: %v", err)
+	}
+	if err := utils.DecryptFileWithPassphrase(encrypted, decrypted, "correct horse battery staple"); err != nil {
+		t.Fatalf("Error decrypting with passphrase: %v", err)
+	}
+	assertSameChecksum(t, utils, source, decrypted)
+
+	if err := utils.DecryptFileWithPassphrase(encrypted, decrypted, "wrong passphrase"); !errors.Is(err, mft.ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for a wrong passphrase, got: %v", err)
+	}
+	if err := utils.DecryptFile(encrypted, decrypted, testKey); !errors.Is(err, mft.ErrKeyModeMismatch) {
+		t.Errorf("Expected ErrKeyModeMismatch when using a raw key, got: %v", err)
+	}
+
+	// Hostile scrypt parameters are rejected before any key is derived.
+	original, _ := os.ReadFile(encrypted)
+	for _, params := range []struct {
+		logN byte
+		r, p uint32
+	}{{22, 1024, 1}, {22, 8, 1}, {10, 1 << 20, 1}, {10, 1, 1 << 20}} {
+		hostile := append([]byte(nil), original...)
+		hostile[4+15] = params.logN
+		binary.BigEndian.PutUint32(hostile[4+15+1:], params.r)
+		binary.BigEndian.PutUint32(hostile[4+15+5:], params.p)
+		os.WriteFile(encrypted, hostile, 0644)
+		if err := utils.DecryptFileWithPassphrase(encrypted, decrypted, "correct horse battery staple"); !errors.Is(err, mft.ErrInvalidKDFParams) {
+			t.Errorf("Expected ErrInvalidKDFParams for N=2^%d r=%d p=%d, got: %v", params.logN, params.r, params.p, err)
+		}
+	}
+	os.WriteFile(encrypted, original, 0644)
+
+	// The salt is part of the authenticated header.
+	ciphertext, _ := os.ReadFile(encrypted)
+	ciphertext[4+15+9] ^= 0x01
+	os.WriteFile(encrypted, ciphertext, 0644)
+	if err := utils.DecryptFileWithPassphrase(encrypted, decrypted, "correct horse battery staple"); !errors.Is(err, mft.ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for a modified salt, got: %v", err)
+	}
+}
@@ -2,6 +2,22 @@ module github.com/madhu72/mftkit
 
 go 1.22
 
-require github.com/fsnotify/fsnotify v1.7.0
+require (
+	github.com/ProtonMail/go-crypto v1.1.3
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/dsnet/compress v0.0.1
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/klauspost/compress v1.17.11
+	github.com/klauspost/reedsolomon v1.12.4
+	github.com/pkg/sftp v1.13.7
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/crypto v0.31.0
+	lukechampine.com/blake3 v1.3.0
+)
 
-require golang.org/x/sys v0.4.0 // indirect
+require (
+	github.com/cloudflare/circl v1.3.7 // indirect
+	github.com/klauspost/cpuid/v2 v2.2.8 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+)
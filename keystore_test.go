@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/madhu72/mftkit/mft"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeystoreRotation(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	storePath := filepath.Join(dir, "keys.mftks")
+
+	store, err := utils.CreateKeystore(storePath, "master-pass")
+	if err != nil {
+		t.Fatalf("Error creating keystore: %v", err)
+	}
+	if err := store.AddKey("acme-aes", mft.KeyTypeSymmetric, "ACME Bank", nil, time.Time{}); err != nil {
+		t.Fatalf("Error adding key: %v", err)
+	}
+	if err := store.AddKey("acme-aes", mft.KeyTypeSymmetric, "ACME Bank", nil, time.Time{}); !errors.Is(err, mft.ErrKeyExists) {
+		t.Errorf("Expected ErrKeyExists, got: %v", err)
+	}
+
+	source := filepath.Join(dir, "plain.bin")
+	writeTestFile(t, source, 100<<10)
+	before := filepath.Join(dir, "before.bin")
+	if err := utils.EncryptFileWithKeyID(source, before, store, "acme-aes"); err != nil {
+		t.Fatalf("Error encrypting with key ID: %v", err)
+	}
+
+	version, err := store.RotateKey("acme-aes", nil, time.Now().Add(24*time.Hour))
+	if err != nil || version != 2 {
+		t.Fatalf("Expected rotation to version 2, got %d (%v)", version, err)
+	}
+	after := filepath.Join(dir, "after.bin")
+	if err := utils.EncryptFileWithKeyID(source, after, store, "acme-aes"); err != nil {
+		t.Fatalf("Error encrypting with rotated key: %v", err)
+	}
+
+	if _, err := utils.OpenKeystore(storePath, "wrong-pass"); !errors.Is(err, mft.ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for a wrong master passphrase, got: %v", err)
+	}
+	reopened, err := utils.OpenKeystore(storePath, "master-pass")
+	if err != nil {
+		t.Fatalf("Error opening keystore: %v", err)
+	}
+	key, err := reopened.GetKey("acme-aes")
+	if err != nil || key.Partner != "ACME Bank" || len(key.Versions) != 2 {
+		t.Fatalf("Expected two versions of the ACME key, got: %+v (%v)", key, err)
+	}
+
+	for path, expected := range map[string]int{before: 1, after: 2} {
+		id, number, err := utils.FileKeyID(path)
+		if err != nil || id != "acme-aes" || number != expected {
+			t.Errorf("Expected acme-aes version %d in %s, got %s version %d (%v)", expected, filepath.Base(path), id, number, err)
+		}
+		decrypted := filepath.Join(dir, "decrypted.bin")
+		if err := utils.DecryptFileWithKeystore(path, decrypted, reopened); err != nil {
+			t.Fatalf("Error decrypting %s: %v", filepath.Base(path), err)
+		}
+		assertSameChecksum(t, utils, source, decrypted)
+	}
+
+	if err := reopened.AddKey("old", mft.KeyTypeSymmetric, "Retired Partner", nil, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("Error adding expired key: %v", err)
+	}
+	if err := utils.EncryptFileWithKeyID(source, filepath.Join(dir, "old.bin"), reopened, "old"); !errors.Is(err, mft.ErrKeyExpired) {
+		t.Errorf("Expected ErrKeyExpired, got: %v", err)
+	}
+	if err := reopened.DeleteKey("acme-aes"); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	if err := utils.DecryptFileWithKeystore(after, filepath.Join(dir, "decrypted.bin"), reopened); !errors.Is(err, mft.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound after deleting the key, got: %v", err)
+	}
+}
+
+func TestKeystorePGPAndTLS(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+
+	store, err := utils.CreateKeystore(filepath.Join(dir, "keys.mftks"), "master-pass")
+	if err != nil {
+		t.Fatalf("Error creating keystore: %v", err)
+	}
+
+	_, publicPath := writePGPKeys(t, dir, "bank", "bank-pass")
+	publicKey, _ := os.ReadFile(publicPath)
+	if err := store.AddKey("bank-pgp", mft.KeyTypePGP, "Bank", publicKey, time.Time{}); err != nil {
+		t.Fatalf("Error adding PGP key: %v", err)
+	}
+	keyring, err := store.PGPKeyRing("bank-pgp")
+	if err != nil || len(keyring) != 1 {
+		t.Fatalf("Expected one PGP entity, got %d (%v)", len(keyring), err)
+	}
+
+	pki := newTestPKI(t)
+	certPEM, _ := os.ReadFile(pki.serverCert)
+	keyPEM, _ := os.ReadFile(pki.serverKey)
+	if err := store.AddKey("server-tls", mft.KeyTypeTLS, "", append(certPEM, keyPEM...), time.Time{}); err != nil {
+		t.Fatalf("Error adding TLS key: %v", err)
+	}
+	if _, err := store.TLSCertificate("server-tls"); err != nil {
+		t.Errorf("Error loading TLS certificate: %v", err)
+	}
+	if _, err := store.PGPKeyRing("server-tls"); !errors.Is(err, mft.ErrKeyTypeMismatch) {
+		t.Errorf("Expected ErrKeyTypeMismatch, got: %v", err)
+	}
+
+	if keys := store.ListKeys(); len(keys) != 2 || keys[0].ID != "bank-pgp" || keys[1].ID != "server-tls" {
+		t.Errorf("Expected bank-pgp and server-tls, got: %+v", keys)
+	}
+}
+
+func TestKeystoreHostileScryptParams(t *testing.T) {
+	utils := mft.NewMFT()
+	storePath := filepath.Join(t.TempDir(), "keys.mftks")
+	if _, err := utils.CreateKeystore(storePath, "master-pass"); err != nil {
+		t.Fatalf("Error creating keystore: %v", err)
+	}
+
+	// N=2^22 with r=1024 would make scrypt allocate 512 GiB.
+	data, _ := os.ReadFile(storePath)
+	data[4+15] = 22
+	binary.BigEndian.PutUint32(data[4+15+1:], 1024)
+	os.WriteFile(storePath, data, 0600)
+	if _, err := utils.OpenKeystore(storePath, "master-pass"); !errors.Is(err, mft.ErrInvalidKDFParams) {
+		t.Errorf("Expected ErrInvalidKDFParams for a tampered keystore, got: %v", err)
+	}
+}
+
+func TestKeystoreFailedSaveRollsBack(t *testing.T) {
+	utils := mft.NewMFT()
+	storePath := filepath.Join(t.TempDir(), "keys.mftks")
+	store, err := utils.CreateKeystore(storePath, "master-pass")
+	if err != nil {
+		t.Fatalf("Error creating keystore: %v", err)
+	}
+	if err := store.AddKey("kept", mft.KeyTypeSymmetric, "Partner", nil, time.Time{}); err != nil {
+		t.Fatalf("Error adding key: %v", err)
+	}
+
+	// A directory in place of the temporary file makes every save fail.
+	os.Mkdir(storePath+".tmp", 0755)
+	if err := store.AddKey("failed", mft.KeyTypeSymmetric, "Partner", nil, time.Time{}); err == nil {
+		t.Fatalf("Expected AddKey to fail")
+	}
+	if _, err := store.RotateKey("kept", nil, time.Time{}); err == nil {
+		t.Fatalf("Expected RotateKey to fail")
+	}
+	if err := store.DeleteKey("kept"); err == nil {
+		t.Fatalf("Expected DeleteKey to fail")
+	}
+	os.Remove(storePath + ".tmp")
+
+	// The next successful save must not persist the failed changes.
+	if err := store.AddKey("later", mft.KeyTypeSymmetric, "Partner", nil, time.Time{}); err != nil {
+		t.Fatalf("Error adding key: %v", err)
+	}
+	reopened, err := utils.OpenKeystore(storePath, "master-pass")
+	if err != nil {
+		t.Fatalf("Error opening keystore: %v", err)
+	}
+	for _, keystore := range []*mft.Keystore{store, reopened} {
+		keys := keystore.ListKeys()
+		if len(keys) != 2 || keys[0].ID != "kept" || keys[1].ID != "later" || len(keys[0].Versions) != 1 {
+			t.Errorf("Expected only kept (one version) and later, got: %+v", keys)
+		}
+	}
+}
+
+func TestKeystoreLongKeyID(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	store, err := utils.CreateKeystore(filepath.Join(dir, "keys.mftks"), "master-pass")
+	if err != nil {
+		t.Fatalf("Error creating keystore: %v", err)
+	}
+
+	// The key reference adds 6 bytes to the ID in a header field of at most 0xffff.
+	if err := store.AddKey(strings.Repeat("k", 0xffff-5), mft.KeyTypeSymmetric, "Partner", nil, time.Time{}); err == nil {
+		t.Errorf("Expected AddKey to reject a key ID that does not fit the header")
+	}
+	keyID := strings.Repeat("k", 0xffff-6)
+	if err := store.AddKey(keyID, mft.KeyTypeSymmetric, "Partner", nil, time.Time{}); err != nil {
+		t.Fatalf("Error adding key: %v", err)
+	}
+	plainPath := filepath.Join(dir, "plain.txt")
+	data := writeTestFile(t, plainPath, 1000)
+	if err := utils.EncryptFileWithKeyID(plainPath, plainPath+".enc", store, keyID); err != nil {
+		t.Fatalf("Error encrypting file: %v", err)
+	}
+	if err := utils.DecryptFileWithKeystore(plainPath+".enc", plainPath+".dec", store); err != nil {
+		t.Fatalf("Error decrypting file: %v", err)
+	}
+	if decrypted, _ := os.ReadFile(plainPath + ".dec"); string(decrypted) != string(data) {
+		t.Errorf("Expected the decrypted file to match the original")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"github.com/madhu72/mftkit/mft"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMerkleTreeRepair(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source.bin")
+	original := writeTestFile(t, sourcePath, 10*1024+100)
+
+	sidecarPath, err := utils.WriteMerkleSidecar(sourcePath, 1024)
+	if err != nil {
+		t.Fatalf("Error writing merkle sidecar: %v", err)
+	}
+	tree, err := utils.ReadMerkleTree(sidecarPath)
+	if err != nil {
+		t.Fatalf("Error reading merkle sidecar: %v", err)
+	}
+	if len(tree.Leaves) != 11 || tree.Size != int64(len(original)) {
+		t.Fatalf("Expected 11 chunks over %d bytes, got: %d over %d", len(original), len(tree.Leaves), tree.Size)
+	}
+
+	copyPath := filepath.Join(dir, "copy.bin")
+	damaged := append([]byte(nil), original...)
+	damaged[100] ^= 0xff
+	damaged[3*1024] ^= 0xff
+	damaged[4*1024+5] ^= 0xff
+	damaged[10*1024+50] ^= 0xff
+	os.WriteFile(copyPath, damaged, 0644)
+
+	ranges, err := utils.DiffFileWithMerkleTree(copyPath, tree)
+	if err != nil {
+		t.Fatalf("Error comparing with merkle tree: %v", err)
+	}
+	expected := []mft.ByteRange{{Offset: 0, Length: 1024}, {Offset: 3 * 1024, Length: 2048}, {Offset: 10 * 1024, Length: 100}}
+	if len(ranges) != len(expected) {
+		t.Fatalf("Expected ranges %v, got: %v", expected, ranges)
+	}
+	for i := range expected {
+		if ranges[i] != expected[i] {
+			t.Errorf("Expected range %v, got: %v", expected[i], ranges[i])
+		}
+	}
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		t.Fatalf("Error opening source: %v", err)
+	}
+	defer source.Close()
+	if err := utils.PatchFileRanges(copyPath, source, ranges, tree.Size); err != nil {
+		t.Fatalf("Error patching ranges: %v", err)
+	}
+	if repaired, _ := os.ReadFile(copyPath); !bytes.Equal(repaired, original) {
+		t.Errorf("Expected the patched copy to match the source")
+	}
+	if ranges, err := utils.DiffFileWithMerkleTree(copyPath, tree); err != nil || len(ranges) != 0 {
+		t.Errorf("Expected no differences after patching, got: %v, %v", ranges, err)
+	}
+
+	// A truncated copy differs from the cut onwards.
+	os.WriteFile(copyPath, original[:5000], 0644)
+	ranges, err = utils.DiffFileWithMerkleTree(copyPath, tree)
+	if err != nil || len(ranges) != 1 || ranges[0].Offset != 4*1024 || ranges[0].Offset+ranges[0].Length != tree.Size {
+		t.Errorf("Expected one range from 4096 to the end, got: %v, %v", ranges, err)
+	}
+}
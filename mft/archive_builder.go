@@ -0,0 +1,264 @@
+package mft
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveBuilderOptions controls which entries an ArchiveBuilder writes and how
+// they are named.
+type ArchiveBuilderOptions struct {
+	// Include lists glob patterns (path.Match syntax) an entry name or its base
+	// name must match. An empty list includes everything.
+	Include []string
+	// Exclude lists glob patterns for entries to leave out. An excluded
+	// directory is skipped with everything below it.
+	Exclude []string
+	// Rename, if set, maps an entry name to the name written to the archive.
+	// Returning an empty string leaves the entry out.
+	Rename func(name string) string
+	// Compression names a codec, such as "gzip" or "zstd", that compresses a tar
+	// stream. It is ignored for zip archives, whose entries are deflated.
+	Compression string
+	// Password, if set, encrypts the files of a zip archive with WinZip AES-256.
+	// It is ignored for tar archives.
+	Password string
+}
+
+// ArchiveBuilder streams a zip or tar archive to an io.Writer without staging
+// files on disk. Entry names use forward slashes.
+type ArchiveBuilder struct {
+	options    ArchiveBuilderOptions
+	zipWriter  *zip.Writer
+	tarWriter  *tar.Writer
+	compressor io.WriteCloser
+	// encrypted is the open WinZip AES entry, finished before the next entry.
+	encrypted *zipAESWriter
+}
+
+// NewZipBuilder returns a builder that writes a zip archive to w.
+func (m *MFT) NewZipBuilder(w io.Writer, options ArchiveBuilderOptions) *ArchiveBuilder {
+	return &ArchiveBuilder{options: options, zipWriter: zip.NewWriter(w)}
+}
+
+// NewTarBuilder returns a builder that writes a tar archive, compressed with
+// options.Compression if set, to w.
+func (m *MFT) NewTarBuilder(w io.Writer, options ArchiveBuilderOptions) (*ArchiveBuilder, error) {
+	builder := &ArchiveBuilder{options: options}
+	if options.Compression != "" {
+		compressor, err := m.NewCompressWriter(w, options.Compression, DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		builder.compressor = compressor
+		w = compressor
+	}
+	builder.tarWriter = tar.NewWriter(w)
+	return builder, nil
+}
+
+// AddFile adds a single file under name, or under its base name if name is empty.
+// A symlink is followed, so the entry holds the contents of the file it points to.
+func (b *ArchiveBuilder) AddFile(filePath, name string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New("not a file: " + filePath)
+	}
+	if name == "" {
+		name = filepath.Base(filePath)
+	}
+	if !b.included(name) {
+		return nil
+	}
+	return b.add(filePath, info, name)
+}
+
+// AddDirectory adds the tree below dir with names relative to dir, placed under
+// prefix if it is not empty. Symlinks below dir are stored as links.
+func (b *ArchiveBuilder) AddDirectory(dir, prefix string) error {
+	prefix = strings.Trim(filepath.ToSlash(prefix), "/")
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		name := path.Join(prefix, filepath.ToSlash(relPath))
+		if name == "." || name == "" {
+			return nil
+		}
+		if b.excluded(name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !b.includedByPattern(name) {
+			// Directories are still walked for matching files below them.
+			return nil
+		}
+		return b.add(filePath, info, name)
+	})
+}
+
+// AddGlob adds the files and directories matching a filepath.Glob pattern.
+// Matches are named by their base name; directories are added recursively.
+// Like AddFile, symlinked files are added with the contents they point to.
+func (b *ArchiveBuilder) AddGlob(pattern string) error {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			var dir string
+			if dir, err = filepath.EvalSymlinks(match); err == nil {
+				err = b.AddDirectory(dir, filepath.Base(match))
+			}
+		} else {
+			err = b.AddFile(match, "")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close finishes the archive. It does not close the underlying writer.
+func (b *ArchiveBuilder) Close() error {
+	if b.zipWriter != nil {
+		if err := b.finishEncrypted(); err != nil {
+			return err
+		}
+		return b.zipWriter.Close()
+	}
+	if err := b.tarWriter.Close(); err != nil {
+		return err
+	}
+	if b.compressor != nil {
+		return b.compressor.Close()
+	}
+	return nil
+}
+
+func (b *ArchiveBuilder) included(name string) bool {
+	return !b.excluded(name) && b.includedByPattern(name)
+}
+
+func (b *ArchiveBuilder) includedByPattern(name string) bool {
+	return len(b.options.Include) == 0 || matchAny(b.options.Include, name)
+}
+
+func (b *ArchiveBuilder) excluded(name string) bool {
+	return matchAny(b.options.Exclude, name)
+}
+
+// matchAny reports whether name or its base name matches one of the patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, path.Base(name)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// finishEncrypted completes the open WinZip AES entry, if any.
+func (b *ArchiveBuilder) finishEncrypted() error {
+	if b.encrypted == nil {
+		return nil
+	}
+	encrypted := b.encrypted
+	b.encrypted = nil
+	return encrypted.Close()
+}
+
+// add writes one file, directory or symlink entry.
+func (b *ArchiveBuilder) add(filePath string, info os.FileInfo, name string) error {
+	if b.options.Rename != nil {
+		if name = b.options.Rename(name); name == "" {
+			return nil
+		}
+	}
+
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(filePath); err != nil {
+			return err
+		}
+	}
+
+	var writer io.Writer
+	if b.zipWriter != nil {
+		if err := b.finishEncrypted(); err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+		if b.options.Password != "" && info.Mode().IsRegular() {
+			if b.encrypted, err = createEncryptedZipEntry(b.zipWriter, header, b.options.Password); err != nil {
+				return err
+			}
+			writer = b.encrypted
+		} else if writer, err = b.zipWriter.CreateHeader(header); err != nil {
+			return err
+		}
+		if link != "" {
+			_, err = io.WriteString(writer, link)
+			return err
+		}
+	} else {
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := b.tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		writer = b.tarWriter
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(writer, file)
+	return err
+}
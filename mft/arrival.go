@@ -0,0 +1,294 @@
+package mft
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ReadyStrategy selects how WatchArrivals decides that a file is complete.
+type ReadyStrategy int
+
+const (
+	// ReadyStableSize reports a file once its size and modification time have not
+	// changed for ArrivalOptions.StableFor.
+	ReadyStableSize ReadyStrategy = iota
+	// ReadyTriggerFile reports a file when a trigger file named after it appears,
+	// such as report.csv.done or report.done for report.csv. If the trigger is
+	// written first, the file is reported when it is created, so it should be
+	// renamed into place.
+	ReadyTriggerFile
+	// ReadyRename reports a file when it is renamed from a temporary name, such
+	// as report.csv.filepart, to its final name.
+	ReadyRename
+	// ReadyLockProbe reports a file once an exclusive flock on it succeeds and its
+	// size has stopped changing. It only helps with writers that hold a lock.
+	ReadyLockProbe
+)
+
+// Defaults used by WatchArrivals for zero ArrivalOptions fields.
+const (
+	DefaultStableFor    = 5 * time.Second
+	DefaultPollInterval = time.Second
+)
+
+// renameWindow is how long after a temporary file is renamed away the Create
+// event of its final name is accepted for ReadyRename.
+const renameWindow = time.Minute
+
+var (
+	defaultTriggerSuffixes = []string{".done", ".ok"}
+	defaultTempSuffixes    = []string{".tmp", ".part", ".filepart"}
+)
+
+// ArrivalOptions controls WatchArrivals.
+type ArrivalOptions struct {
+	Strategy ReadyStrategy
+	// StableFor is how long size and modification time must stay unchanged for
+	// ReadyStableSize.
+	StableFor time.Duration
+	// PollInterval is how often files are checked for ReadyStableSize and
+	// ReadyLockProbe.
+	PollInterval time.Duration
+	// TriggerSuffixes name trigger files for ReadyTriggerFile. Zero means .done
+	// and .ok. Include patterns in Watch must let the trigger files through.
+	TriggerSuffixes []string
+	// TempSuffixes mark files still being written. They are never reported,
+	// and ReadyRename reports the file they are renamed to. Zero means .tmp,
+	// .part and .filepart.
+	TempSuffixes []string
+	// Watch filters the tree and reports errors as for WatchTree. Its Debounce
+	// is not used.
+	Watch WatchOptions
+}
+
+// WatchArrivals watches the tree below root like WatchTree and calls ready once
+// for every file that has arrived completely according to options.Strategy,
+// instead of for each write. Callbacks run one at a time. It returns nil when
+// ctx is cancelled.
+func (m *MFT) WatchArrivals(ctx context.Context, root string, options ArrivalOptions, ready func(filePath string)) error {
+	if options.StableFor <= 0 {
+		options.StableFor = DefaultStableFor
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultPollInterval
+	}
+	if len(options.TriggerSuffixes) == 0 {
+		options.TriggerSuffixes = defaultTriggerSuffixes
+	}
+	if len(options.TempSuffixes) == 0 {
+		options.TempSuffixes = defaultTempSuffixes
+	}
+
+	tracker := &arrivalTracker{
+		options:    options,
+		ready:      ready,
+		candidates: make(map[string]*arrivalCandidate),
+		renamed:    make(map[string]time.Time),
+		fired:      make(map[string]bool),
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	if options.Strategy == ReadyStableSize || options.Strategy == ReadyLockProbe {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracker.poll(ctx)
+		}()
+	}
+	defer wg.Wait()
+
+	watchOptions := options.Watch
+	watchOptions.Debounce = -1
+	return m.WatchTree(ctx, root, watchOptions, tracker.handle)
+}
+
+type arrivalCandidate struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+type arrivalTracker struct {
+	options ArrivalOptions
+	ready   func(filePath string)
+
+	mu         sync.Mutex
+	candidates map[string]*arrivalCandidate
+	// renamed holds final names whose temporary file was just renamed.
+	renamed map[string]time.Time
+	// fired holds trigger files that already reported their data file.
+	fired map[string]bool
+}
+
+func (a *arrivalTracker) handle(event FileEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if event.Op.Has(fsnotify.Remove) || event.Op.Has(fsnotify.Rename) {
+		delete(a.candidates, event.Name)
+		delete(a.fired, event.Name)
+		if finalName, temporary := trimAnySuffix(event.Name, a.options.TempSuffixes); temporary && event.Op.Has(fsnotify.Rename) {
+			for name, renamedAt := range a.renamed {
+				if time.Since(renamedAt) > renameWindow {
+					delete(a.renamed, name)
+				}
+			}
+			a.renamed[finalName] = time.Now()
+		}
+		return
+	}
+	if _, temporary := trimAnySuffix(event.Name, a.options.TempSuffixes); temporary {
+		return
+	}
+	info, err := os.Stat(event.Name)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	switch a.options.Strategy {
+	case ReadyStableSize, ReadyLockProbe:
+		candidate, exists := a.candidates[event.Name]
+		if !exists {
+			candidate = &arrivalCandidate{}
+			a.candidates[event.Name] = candidate
+		}
+		candidate.size, candidate.modTime, candidate.since = info.Size(), info.ModTime(), time.Now()
+	case ReadyTriggerFile:
+		if !event.Op.Has(fsnotify.Create) {
+			break
+		}
+		// Whichever of the data file and its trigger is seen second reports
+		// the file, once per trigger.
+		if dataPath, isTrigger := a.triggeredFile(event.Name); isTrigger {
+			if dataPath != "" && !a.fired[event.Name] {
+				a.fired[event.Name] = true
+				a.ready(dataPath)
+			}
+		} else if triggerPath := a.existingTrigger(event.Name); triggerPath != "" && !a.fired[triggerPath] {
+			a.fired[triggerPath] = true
+			a.ready(event.Name)
+		}
+	case ReadyRename:
+		// The temporary name's Rename event comes just before the final name's Create.
+		renamedAt, wasRenamed := a.renamed[event.Name]
+		delete(a.renamed, event.Name)
+		if event.Op.Has(fsnotify.Create) && wasRenamed && time.Since(renamedAt) < renameWindow {
+			a.ready(event.Name)
+		}
+	}
+}
+
+// triggeredFile reports whether triggerPath is a trigger file and returns the
+// data file it marks, if that exists: report.csv for report.csv.done, or a file
+// with the same base name, such as report.csv, for report.done.
+func (a *arrivalTracker) triggeredFile(triggerPath string) (string, bool) {
+	dataPath, isTrigger := trimAnySuffix(triggerPath, a.options.TriggerSuffixes)
+	if !isTrigger {
+		return "", false
+	}
+	if info, err := os.Stat(dataPath); err == nil && info.Mode().IsRegular() {
+		return dataPath, true
+	}
+	matches, _ := filepath.Glob(dataPath + ".*")
+	for _, match := range matches {
+		if _, nested := trimAnySuffix(match, a.options.TriggerSuffixes); nested {
+			continue
+		}
+		if _, temporary := trimAnySuffix(match, a.options.TempSuffixes); temporary {
+			continue
+		}
+		if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+			return match, true
+		}
+	}
+	return "", true
+}
+
+// existingTrigger returns the trigger file for dataPath, such as
+// report.csv.done or report.done for report.csv, if it already exists.
+func (a *arrivalTracker) existingTrigger(dataPath string) string {
+	stem := strings.TrimSuffix(dataPath, filepath.Ext(dataPath))
+	for _, suffix := range a.options.TriggerSuffixes {
+		for _, triggerPath := range []string{dataPath + suffix, stem + suffix} {
+			if info, err := os.Stat(triggerPath); err == nil && info.Mode().IsRegular() {
+				return triggerPath
+			}
+		}
+	}
+	return ""
+}
+
+// poll checks the candidates every PollInterval until ctx is done.
+func (a *arrivalTracker) poll(ctx context.Context) {
+	ticker := time.NewTicker(a.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.check(now)
+		}
+	}
+}
+
+func (a *arrivalTracker) check(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for filePath, candidate := range a.candidates {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			delete(a.candidates, filePath)
+			continue
+		}
+		if info.Size() != candidate.size || !info.ModTime().Equal(candidate.modTime) {
+			candidate.size, candidate.modTime, candidate.since = info.Size(), info.ModTime(), now
+			continue
+		}
+
+		complete := false
+		switch a.options.Strategy {
+		case ReadyStableSize:
+			complete = now.Sub(candidate.since) >= a.options.StableFor
+		case ReadyLockProbe:
+			complete = canLockExclusively(filePath)
+		}
+		if complete {
+			delete(a.candidates, filePath)
+			a.ready(filePath)
+		}
+	}
+}
+
+// canLockExclusively reports whether a non-blocking exclusive flock on filePath
+// succeeds, meaning no other process holds a lock on it.
+func canLockExclusively(filePath string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return false
+	}
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	return true
+}
+
+// trimAnySuffix removes the first of suffixes that name ends with.
+func trimAnySuffix(name string, suffixes []string) (string, bool) {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return strings.TrimSuffix(name, suffix), true
+		}
+	}
+	return name, false
+}
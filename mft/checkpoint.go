@@ -0,0 +1,223 @@
+package mft
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"os"
+)
+
+// checkpointInterval is the number of bytes written between two checkpoints.
+var checkpointInterval int64 = 8 << 20
+
+// TransferCheckpoint records how much of a transfer has safely reached disk.
+// It is stored as JSON next to the destination while the transfer is incomplete.
+type TransferCheckpoint struct {
+	Offset      int64  `json:"offset"`
+	PartialHash string `json:"partial_hash"`
+	Size        int64  `json:"size"`
+	Checksum    string `json:"checksum"`
+}
+
+// checkpointPath returns the path of the checkpoint file kept for destination.
+func checkpointPath(destination string) string {
+	return destination + ".mftckpt"
+}
+
+// partialPath returns the path that receives data for destination until the transfer completes.
+func partialPath(destination string) string {
+	return destination + ".mftpart"
+}
+
+// LoadTransferCheckpoint reads the checkpoint of an incomplete transfer to destination.
+func (m *MFT) LoadTransferCheckpoint(destination string) (*TransferCheckpoint, error) {
+	return loadCheckpoint(destination)
+}
+
+func loadCheckpoint(destination string) (*TransferCheckpoint, error) {
+	data, err := os.ReadFile(checkpointPath(destination))
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoint TransferCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// checkpointWriter writes transfer data into the partial file and periodically
+// records a checkpoint once the written data has been synced to disk.
+type checkpointWriter struct {
+	destination string
+	file        *os.File
+	hash        hash.Hash
+	checkpoint  TransferCheckpoint
+	lastSaved   int64
+}
+
+// resumeTransfer opens the partial file for destination. When a checkpoint for the
+// same size and checksum exists and the partial data still hashes to the recorded
+// value, writing continues at the checkpoint offset; otherwise it starts from zero.
+func resumeTransfer(destination string, size int64, checksum string) (*checkpointWriter, error) {
+	if checkpoint, err := loadCheckpoint(destination); err == nil && checksum != "" &&
+		checkpoint.Size == size && checkpoint.Checksum == checksum && checkpoint.Offset <= size {
+		if w, err := openCheckpointWriter(destination, *checkpoint); err == nil {
+			return w, nil
+		}
+	}
+
+	file, err := os.OpenFile(partialPath(destination), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &checkpointWriter{
+		destination: destination,
+		file:        file,
+		hash:        sha256.New(),
+		checkpoint:  TransferCheckpoint{Size: size, Checksum: checksum},
+	}
+	if err := w.save(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// openCheckpointWriter verifies the partial data up to checkpoint.Offset and positions
+// the writer right after it.
+func openCheckpointWriter(destination string, checkpoint TransferCheckpoint) (*checkpointWriter, error) {
+	file, err := os.OpenFile(partialPath(destination), os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, file, checkpoint.Offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != checkpoint.PartialHash {
+		file.Close()
+		return nil, errors.New("partial data does not match checkpoint")
+	}
+
+	if err := file.Truncate(checkpoint.Offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(checkpoint.Offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &checkpointWriter{
+		destination: destination,
+		file:        file,
+		hash:        hash,
+		checkpoint:  checkpoint,
+		lastSaved:   checkpoint.Offset,
+	}, nil
+}
+
+// Offset returns the number of bytes written to the partial file so far.
+func (w *checkpointWriter) Offset() int64 {
+	return w.checkpoint.Offset
+}
+
+func (w *checkpointWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	w.checkpoint.Offset += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	if w.checkpoint.Offset-w.lastSaved >= checkpointInterval {
+		if err := w.save(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// save syncs the partial file and records the current offset and partial hash.
+func (w *checkpointWriter) save() error {
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.checkpoint.PartialHash = hex.EncodeToString(w.hash.Sum(nil))
+
+	data, err := json.Marshal(w.checkpoint)
+	if err != nil {
+		return err
+	}
+	tempPath := checkpointPath(w.destination) + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, checkpointPath(w.destination)); err != nil {
+		return err
+	}
+
+	w.lastSaved = w.checkpoint.Offset
+	return nil
+}
+
+// reset discards any partial data and restarts the transfer for a new size and checksum.
+func (w *checkpointWriter) reset(size int64, checksum string) error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.hash.Reset()
+	w.checkpoint = TransferCheckpoint{Size: size, Checksum: checksum}
+	w.lastSaved = 0
+	return w.save()
+}
+
+// abort records a final checkpoint so that a retry can resume, and closes the partial file.
+func (w *checkpointWriter) abort() error {
+	err := w.save()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// discard closes the partial file and removes it together with its checkpoint.
+func (w *checkpointWriter) discard() {
+	w.file.Close()
+	os.Remove(partialPath(w.destination))
+	os.Remove(checkpointPath(w.destination))
+}
+
+// commit verifies the completed partial file with CalculateChecksum and moves it to
+// the destination. It returns the verified checksum.
+func (w *checkpointWriter) commit(m *MFT) (string, error) {
+	if err := w.file.Close(); err != nil {
+		return "", err
+	}
+
+	checksum, err := m.CalculateChecksum(partialPath(w.destination))
+	if err != nil {
+		return "", err
+	}
+	if w.checkpoint.Checksum != "" && checksum != w.checkpoint.Checksum {
+		os.Remove(partialPath(w.destination))
+		os.Remove(checkpointPath(w.destination))
+		return "", ErrChecksumMismatch
+	}
+
+	if err := os.Rename(partialPath(w.destination), w.destination); err != nil {
+		return "", err
+	}
+	os.Remove(checkpointPath(w.destination))
+	return checksum, nil
+}
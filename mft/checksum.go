@@ -0,0 +1,411 @@
+package mft
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ChecksumFormat selects the line format of a checksum manifest.
+type ChecksumFormat int
+
+const (
+	// ChecksumGNU writes "<hash>  <path>" lines as sha256sum and md5sum do.
+	ChecksumGNU ChecksumFormat = iota
+	// ChecksumBSD writes "SHA256 (<path>) = <hash>" lines as BSD sha256 and
+	// sha256sum --tag do.
+	ChecksumBSD
+)
+
+// ChecksumStatus is the result of verifying one manifest entry.
+type ChecksumStatus int
+
+const (
+	ChecksumOK ChecksumStatus = iota
+	ChecksumFailed
+	ChecksumMissing
+)
+
+func (s ChecksumStatus) String() string {
+	switch s {
+	case ChecksumOK:
+		return "OK"
+	case ChecksumFailed:
+		return "FAILED"
+	case ChecksumMissing:
+		return "MISSING"
+	}
+	return "UNKNOWN"
+}
+
+// ChecksumOptions controls checksum manifest generation and verification.
+type ChecksumOptions struct {
+	// Algorithm names a registered hash such as "md5", "sha256" or "blake3".
+	// When generating, empty means "sha256". When verifying, empty means the
+	// BSD tag of each line, the manifest's name (such as MD5SUMS, B2SUMS or
+	// file.sha1) or the length of the hash if no other algorithm shares it.
+	Algorithm string
+	// Format selects GNU or BSD lines when generating. Verification accepts both.
+	Format ChecksumFormat
+	// Workers is the number of files hashed in parallel. Zero means runtime.NumCPU().
+	Workers int
+}
+
+// ErrAmbiguousChecksum is returned when the algorithm of a manifest line can
+// only be guessed from the length of its hash and several algorithms fit.
+var ErrAmbiguousChecksum = errors.New("hash length fits several algorithms; set ChecksumOptions.Algorithm")
+
+// checksumNameAliases maps the names of coreutils tools and their manifests
+// that differ from a registered algorithm, such as b2sum and B2SUMS.
+var checksumNameAliases = map[string]string{"b2": "blake2b-512"}
+
+// ChecksumEntry is one line of a checksum manifest. Path uses forward slashes
+// and is relative to the manifest's directory.
+type ChecksumEntry struct {
+	Path      string
+	Algorithm string
+	Hash      string
+}
+
+// ChecksumResult reports the verification of one manifest entry. Err is set
+// when a file exists but could not be read.
+type ChecksumResult struct {
+	ChecksumEntry
+	Status ChecksumStatus
+	Actual string
+	Err    error
+}
+
+// GenerateChecksumManifest hashes every regular file below dir and writes a
+// sha256sum-compatible manifest, such as SHA256SUMS, to manifestPath. Paths are
+// relative to the manifest's directory, which is normally dir, and sorted.
+func (m *MFT) GenerateChecksumManifest(dir, manifestPath string, options ChecksumOptions) ([]ChecksumEntry, error) {
+	if options.Algorithm == "" {
+		options.Algorithm = "sha256"
+	}
+	manifests, err := m.GenerateChecksumManifests(dir, map[string]string{options.Algorithm: manifestPath}, options)
+	if err != nil {
+		return nil, err
+	}
+	return manifests[options.Algorithm], nil
+}
+
+// GenerateChecksumManifests writes a manifest for each algorithm in
+// manifestPaths, which maps algorithm names to manifest paths, such as
+// SHA256SUMS and MD5SUMS, reading every file below dir only once.
+// options.Algorithm is ignored.
+func (m *MFT) GenerateChecksumManifests(dir string, manifestPaths map[string]string, options ChecksumOptions) (map[string][]ChecksumEntry, error) {
+	var algorithms []string
+	manifests := make(map[string]bool, len(manifestPaths))
+	for algorithm, manifestPath := range manifestPaths {
+		if _, err := newHash(algorithm); err != nil {
+			return nil, err
+		}
+		manifestAbs, err := filepath.Abs(manifestPath)
+		if err != nil {
+			return nil, err
+		}
+		algorithms = append(algorithms, algorithm)
+		manifests[manifestAbs] = true
+	}
+
+	var files []string
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		absPath, err := filepath.Abs(filePath)
+		if err == nil && !manifests[absPath] {
+			files = append(files, absPath)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make([]map[string]string, len(files))
+	errs := make([]error, len(files))
+	forEachParallel(len(files), options.Workers, func(i int) {
+		sums[i], errs[i] = m.HashFile(files[i], algorithms...)
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string][]ChecksumEntry, len(manifestPaths))
+	for algorithm, manifestPath := range manifestPaths {
+		manifestAbs, _ := filepath.Abs(manifestPath)
+		entries := make([]ChecksumEntry, len(files))
+		for i, filePath := range files {
+			relPath, err := filepath.Rel(filepath.Dir(manifestAbs), filePath)
+			if err != nil {
+				return nil, err
+			}
+			entries[i] = ChecksumEntry{Path: filepath.ToSlash(relPath), Algorithm: algorithm, Hash: sums[i][algorithm]}
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+		if err := writeChecksumManifest(manifestPath, entries, options.Format); err != nil {
+			return nil, err
+		}
+		result[algorithm] = entries
+	}
+	return result, nil
+}
+
+// WriteChecksumSidecar writes the checksum of filePath to a sidecar file named
+// after the algorithm, such as report.csv.sha256, and returns its path.
+func (m *MFT) WriteChecksumSidecar(filePath string, options ChecksumOptions) (string, error) {
+	if options.Algorithm == "" {
+		options.Algorithm = "sha256"
+	}
+	sum, err := hashFile(filePath, options.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	sidecarPath := filePath + "." + options.Algorithm
+	entries := []ChecksumEntry{{Path: filepath.Base(filePath), Algorithm: options.Algorithm, Hash: sum}}
+	return sidecarPath, writeChecksumManifest(sidecarPath, entries, options.Format)
+}
+
+// VerifyChecksumManifest checks the files listed in a GNU or BSD checksum
+// manifest or sidecar, relative to the manifest's directory, and reports each
+// entry as OK, FAILED or MISSING. If any entry is not OK the results are
+// returned together with ErrChecksumMismatch.
+func (m *MFT) VerifyChecksumManifest(manifestPath string, options ChecksumOptions) ([]ChecksumResult, error) {
+	entries, err := readChecksumManifest(manifestPath, options.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ChecksumResult, len(entries))
+	forEachParallel(len(entries), options.Workers, func(i int) {
+		filePath := filepath.Join(filepath.Dir(manifestPath), filepath.FromSlash(entries[i].Path))
+		sum, err := hashFile(filePath, entries[i].Algorithm)
+		result := ChecksumResult{ChecksumEntry: entries[i], Actual: sum}
+		switch {
+		case os.IsNotExist(err):
+			result.Status = ChecksumMissing
+		case err != nil:
+			result.Status, result.Err = ChecksumFailed, err
+		case !strings.EqualFold(sum, entries[i].Hash):
+			result.Status = ChecksumFailed
+		}
+		results[i] = result
+	})
+	failed := 0
+	for _, result := range results {
+		if result.Status != ChecksumOK {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%w: %d of %d entries", ErrChecksumMismatch, failed, len(results))
+	}
+	return results, nil
+}
+
+// forEachParallel calls fn for every index below count with up to workers
+// goroutines, or runtime.NumCPU() if workers is not positive.
+func forEachParallel(count, workers int, fn func(i int)) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+func writeChecksumManifest(manifestPath string, entries []ChecksumEntry, format ChecksumFormat) error {
+	var builder strings.Builder
+	for _, entry := range entries {
+		// Like coreutils, names with a backslash or line break are escaped and
+		// the line is marked with a leading backslash.
+		name, prefix := entry.Path, ""
+		if strings.ContainsAny(name, "\\\n\r") {
+			name = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(name)
+			prefix = `\`
+		}
+		if format == ChecksumBSD {
+			fmt.Fprintf(&builder, "%s%s (%s) = %s\n", prefix, strings.ToUpper(entry.Algorithm), name, entry.Hash)
+		} else {
+			fmt.Fprintf(&builder, "%s%s  %s\n", prefix, entry.Hash, name)
+		}
+	}
+	return os.WriteFile(manifestPath, []byte(builder.String()), 0644)
+}
+
+// readChecksumManifest parses GNU and BSD lines. Blank lines and comments are skipped.
+func readChecksumManifest(manifestPath, algorithm string) ([]ChecksumEntry, error) {
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if algorithm == "" {
+		algorithm = checksumAlgorithmFromName(manifestPath)
+	}
+	var entries []ChecksumEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, ok := parseChecksumLine(line)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: malformed checksum line", manifestPath, lineNumber)
+		}
+		if entry.Algorithm == "" {
+			entry.Algorithm = algorithm
+		}
+		if entry.Algorithm == "" {
+			if entry.Algorithm, err = checksumAlgorithmFromLength(len(entry.Hash)); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", manifestPath, lineNumber, err)
+			}
+		}
+		if _, err := newHash(entry.Algorithm); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", manifestPath, lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func parseChecksumLine(line string) (ChecksumEntry, bool) {
+	escaped := strings.HasPrefix(line, `\`)
+	if escaped {
+		line = line[1:]
+	}
+
+	var entry ChecksumEntry
+	if open := strings.Index(line, " ("); open > 0 && !isHex(line[:open]) && !strings.Contains(line[:open], " ") {
+		// BSD: ALGORITHM (name) = hash
+		closing := strings.LastIndex(line, ") = ")
+		if closing < open {
+			return entry, false
+		}
+		entry.Algorithm = strings.ToLower(line[:open])
+		if entry.Algorithm == "blake2b" {
+			// coreutils tags full-length BLAKE2b digests without a size.
+			entry.Algorithm = "blake2b-512"
+		}
+		entry.Path = line[open+2 : closing]
+		entry.Hash = line[closing+4:]
+	} else {
+		// GNU: hash, a space, then a space (text) or '*' (binary), then name
+		space := strings.IndexByte(line, ' ')
+		if space < 0 || space+2 > len(line) || (line[space+1] != ' ' && line[space+1] != '*') {
+			return entry, false
+		}
+		entry.Hash = line[:space]
+		entry.Path = line[space+2:]
+	}
+	if escaped {
+		entry.Path = unescapeChecksumName(entry.Path)
+	}
+	if entry.Path == "" || !isHex(entry.Hash) {
+		return entry, false
+	}
+	return entry, true
+}
+
+func unescapeChecksumName(name string) string {
+	var builder strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+1 < len(name) {
+			i++
+			switch name[i] {
+			case 'n':
+				builder.WriteByte('\n')
+			case 'r':
+				builder.WriteByte('\r')
+			default:
+				builder.WriteByte(name[i])
+			}
+			continue
+		}
+		builder.WriteByte(name[i])
+	}
+	return builder.String()
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// checksumAlgorithmFromName recognises names such as SHA256SUMS, MD5SUMS and
+// report.csv.sha1 for any registered hash, and B2SUMS for BLAKE2b-512.
+func checksumAlgorithmFromName(manifestPath string) string {
+	name := strings.ToLower(filepath.Base(manifestPath))
+	prefixes := make(map[string]string)
+	for _, algorithm := range hashNames() {
+		prefixes[algorithm] = algorithm
+	}
+	for alias, algorithm := range checksumNameAliases {
+		prefixes[alias] = algorithm
+	}
+	candidates := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		candidates = append(candidates, prefix)
+	}
+	// Longer names first, so the most specific algorithm wins.
+	sort.Slice(candidates, func(i, j int) bool { return len(candidates[i]) > len(candidates[j]) })
+	for _, prefix := range candidates {
+		if strings.HasPrefix(name, prefix+"sum") || strings.HasSuffix(name, "."+prefix) {
+			return prefixes[prefix]
+		}
+	}
+	return ""
+}
+
+// checksumAlgorithmFromLength returns the only registered algorithm whose
+// hex-encoded hash has length digits, such as md5 for 32 digits. A length
+// shared by several algorithms, such as 64 for sha256, sha3-256 and blake3,
+// returns ErrAmbiguousChecksum.
+func checksumAlgorithmFromLength(length int) (string, error) {
+	var matches []string
+	for _, algorithm := range hashNames() {
+		if hasher, err := newHash(algorithm); err == nil && hasher.Size()*2 == length {
+			matches = append(matches, algorithm)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no hash algorithm has %d hex digits", length)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrAmbiguousChecksum, strings.Join(matches, ", "))
+}
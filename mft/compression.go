@@ -0,0 +1,282 @@
+package mft
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	dsnetbzip2 "github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Compression levels accepted by the built-in codecs. Levels between
+// BestSpeed and BestCompression are mapped onto each codec's own scale.
+const (
+	DefaultCompression = 0
+	BestSpeed          = 1
+	BestCompression    = 9
+)
+
+// Codec is a compression format.
+type Codec interface {
+	// Name is the registry name of the codec, for example "gzip".
+	Name() string
+	// Match reports whether header, the first bytes of a stream, is in this format.
+	Match(header []byte) bool
+	// NewWriter returns a writer that compresses into w at the given level.
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	// NewReader returns a reader that decompresses r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// ErrUnknownFormat is returned when no registered codec recognises compressed data.
+var ErrUnknownFormat = errors.New("unknown compression format")
+
+// detectHeaderSize is the number of bytes peeked for format detection.
+const detectHeaderSize = 8
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"gzip":  gzipCodec{},
+		"zstd":  zstdCodec{},
+		"xz":    xzCodec{},
+		"bzip2": bzip2Codec{},
+		"zlib":  zlibCodec{},
+	}
+)
+
+// RegisterCodec adds a codec to the registry or replaces the one with the same name.
+func (m *MFT) RegisterCodec(codec Codec) error {
+	if codec == nil || codec.Name() == "" {
+		return errors.New("codec must have a name")
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[strings.ToLower(codec.Name())] = codec
+	return nil
+}
+
+// GetCodec returns the codec registered under name.
+func (m *MFT) GetCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, exists := codecs[strings.ToLower(name)]
+	if !exists {
+		return nil, errors.New("no codec named: " + name)
+	}
+	return codec, nil
+}
+
+// Codecs returns the names of the registered codecs.
+func (m *MFT) Codecs() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DetectCodec returns the codec whose magic bytes match header.
+func (m *MFT) DetectCodec(header []byte) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	// Check in name order so detection does not depend on map iteration.
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if codecs[name].Match(header) {
+			return codecs[name], nil
+		}
+	}
+	return nil, ErrUnknownFormat
+}
+
+// CompressFileWith compresses a file with the named codec at the given level.
+func (m *MFT) CompressFileWith(inputPath, outputPath, codecName string, level int) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	writer, err := m.NewCompressWriter(outputFile, codecName, level)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, inputFile); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return outputFile.Close()
+}
+
+// NewCompressWriter returns a writer that compresses into w with the named codec.
+// Closing it flushes the compressed stream but does not close w.
+func (m *MFT) NewCompressWriter(w io.Writer, codecName string, level int) (io.WriteCloser, error) {
+	if level < DefaultCompression || level > BestCompression {
+		return nil, errors.New("compression level must be between 0 and 9")
+	}
+	codec, err := m.GetCodec(codecName)
+	if err != nil {
+		return nil, err
+	}
+	return codec.NewWriter(w, level)
+}
+
+// NewDecompressReader detects the compression format of r from its magic bytes
+// and returns a reader of the decompressed data.
+func (m *MFT) NewDecompressReader(r io.Reader) (io.ReadCloser, error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(detectHeaderSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	codec, err := m.DetectCodec(header)
+	if err != nil {
+		return nil, err
+	}
+	return codec.NewReader(reader)
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) Match(header []byte) bool {
+	return bytes.HasPrefix(header, []byte{0x1f, 0x8b})
+}
+
+func (gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == DefaultCompression {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type zlibCodec struct{}
+
+func (zlibCodec) Name() string { return "zlib" }
+
+// Match checks the zlib header: deflate method and a valid header checksum.
+func (zlibCodec) Match(header []byte) bool {
+	return len(header) >= 2 && header[0]&0x0f == 8 && header[0]>>4 <= 7 &&
+		(uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+func (zlibCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == DefaultCompression {
+		level = zlib.DefaultCompression
+	}
+	return zlib.NewWriterLevel(w, level)
+}
+
+func (zlibCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return "zstd" }
+
+func (zstdCodec) Match(header []byte) bool {
+	return bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd})
+}
+
+func (zstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	encoderLevel := zstd.SpeedDefault
+	switch {
+	case level == DefaultCompression:
+	case level <= 2:
+		encoderLevel = zstd.SpeedFastest
+	case level <= 5:
+		encoderLevel = zstd.SpeedDefault
+	case level <= 7:
+		encoderLevel = zstd.SpeedBetterCompression
+	default:
+		encoderLevel = zstd.SpeedBestCompression
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel))
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+type xzCodec struct{}
+
+func (xzCodec) Name() string { return "xz" }
+
+func (xzCodec) Match(header []byte) bool {
+	return bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00})
+}
+
+// xzDictionarySizes follows the dictionary sizes of the xz presets 1 to 9.
+var xzDictionarySizes = [...]int{1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+func (xzCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == DefaultCompression {
+		level = 6
+	}
+	config := xz.WriterConfig{DictCap: xzDictionarySizes[level-1]}
+	return config.NewWriter(w)
+}
+
+func (xzCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	reader, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(reader), nil
+}
+
+type bzip2Codec struct{}
+
+func (bzip2Codec) Name() string { return "bzip2" }
+
+func (bzip2Codec) Match(header []byte) bool {
+	return len(header) >= 4 && bytes.HasPrefix(header, []byte("BZh")) && header[3] >= '1' && header[3] <= '9'
+}
+
+func (bzip2Codec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == DefaultCompression {
+		level = dsnetbzip2.DefaultCompression
+	}
+	return dsnetbzip2.NewWriter(w, &dsnetbzip2.WriterConfig{Level: level})
+}
+
+func (bzip2Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(r)), nil
+}
@@ -0,0 +1,330 @@
+package mft
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Encrypted files start with a header that is authenticated as additional data
+// of every chunk:
+//
+//	magic "MFTE" | version (1) | key mode (1) | chunk size (4) | nonce prefix (7) |
+//	params length (2) | key params
+//
+// The plaintext follows in AES-GCM sealed chunks of chunk size bytes. Each chunk
+// nonce is the nonce prefix, a big-endian chunk counter and a final-chunk flag, so
+// reordered, dropped or truncated chunks fail authentication.
+const (
+	encryptionMagic     = "MFTE"
+	encryptionVersion   = 1
+	encryptionChunkSize = 64 * 1024
+	maxChunkSize        = 16 << 20
+	noncePrefixSize     = 7
+)
+
+// Key modes recorded in the header describe how the file key is obtained.
+const (
+	keyModeRaw byte = iota
+	keyModeScrypt
+	keyModeKeystore
+)
+
+var (
+	// ErrDecryptionFailed is returned when ciphertext or its header has been modified or the key is wrong.
+	ErrDecryptionFailed = errors.New("decryption failed: data is corrupt or the key is wrong")
+	// ErrTruncatedCiphertext is returned when an encrypted stream ends before its final chunk.
+	ErrTruncatedCiphertext = errors.New("encrypted data is truncated")
+	// ErrKeyModeMismatch is returned when a file was encrypted with a different kind of key.
+	ErrKeyModeMismatch = errors.New("file was encrypted with a different kind of key")
+	// ErrLegacyCiphertext is returned for files in the legacy AES-CFB format unless DecryptOptions.AllowLegacyCFB is set.
+	ErrLegacyCiphertext = errors.New("legacy AES-CFB ciphertext requires AllowLegacyCFB")
+)
+
+// DecryptOptions controls DecryptFileWithOptions.
+type DecryptOptions struct {
+	// AllowLegacyCFB accepts files written by earlier versions with unauthenticated AES-CFB.
+	AllowLegacyCFB bool
+}
+
+// encryptionHeader is the parsed form of the header of an encrypted file.
+type encryptionHeader struct {
+	keyMode     byte
+	chunkSize   uint32
+	noncePrefix [noncePrefixSize]byte
+	params      []byte
+}
+
+func newEncryptionHeader(keyMode byte, params []byte) (*encryptionHeader, error) {
+	if len(params) > 0xffff {
+		return nil, errors.New("encryption header parameters are too long")
+	}
+	header := &encryptionHeader{keyMode: keyMode, chunkSize: encryptionChunkSize, params: params}
+	if _, err := io.ReadFull(rand.Reader, header.noncePrefix[:]); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func (h *encryptionHeader) marshal() []byte {
+	data := make([]byte, 0, len(encryptionMagic)+15+len(h.params))
+	data = append(data, encryptionMagic...)
+	data = append(data, encryptionVersion, h.keyMode)
+	data = binary.BigEndian.AppendUint32(data, h.chunkSize)
+	data = append(data, h.noncePrefix[:]...)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(h.params)))
+	return append(data, h.params...)
+}
+
+// readEncryptionHeader reads the header that follows the magic and returns it with
+// its raw bytes, which are authenticated with every chunk.
+func readEncryptionHeader(r io.Reader) (*encryptionHeader, []byte, error) {
+	fixed := make([]byte, 15)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, nil, ErrTruncatedCiphertext
+	}
+	if fixed[0] != encryptionVersion {
+		return nil, nil, errors.New("unsupported encryption format version")
+	}
+
+	header := &encryptionHeader{keyMode: fixed[1], chunkSize: binary.BigEndian.Uint32(fixed[2:6])}
+	copy(header.noncePrefix[:], fixed[6:13])
+	if header.chunkSize == 0 || header.chunkSize > maxChunkSize {
+		return nil, nil, ErrDecryptionFailed
+	}
+
+	header.params = make([]byte, binary.BigEndian.Uint16(fixed[13:15]))
+	if _, err := io.ReadFull(r, header.params); err != nil {
+		return nil, nil, ErrTruncatedCiphertext
+	}
+
+	raw := append([]byte(encryptionMagic), fixed...)
+	return header, append(raw, header.params...), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix [noncePrefixSize]byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix[:]...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if final {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// EncryptStream encrypts src into dst with AES-GCM in the chunked stream format.
+// The key must be 16, 24 or 32 bytes long.
+func (m *MFT) EncryptStream(dst io.Writer, src io.Reader, key []byte) error {
+	header, err := newEncryptionHeader(keyModeRaw, nil)
+	if err != nil {
+		return err
+	}
+	return encryptChunks(dst, src, key, header)
+}
+
+// DecryptStream verifies and decrypts src, written by EncryptStream, into dst.
+// Only authenticated data is written to dst.
+func (m *MFT) DecryptStream(dst io.Writer, src io.Reader, key []byte) error {
+	reader := bufio.NewReader(src)
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != encryptionMagic {
+		return ErrDecryptionFailed
+	}
+
+	header, rawHeader, err := readEncryptionHeader(reader)
+	if err != nil {
+		return err
+	}
+	if header.keyMode != keyModeRaw {
+		return ErrKeyModeMismatch
+	}
+	return decryptChunks(dst, reader, key, header, rawHeader)
+}
+
+func encryptChunks(dst io.Writer, src io.Reader, key []byte, header *encryptionHeader) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	rawHeader := header.marshal()
+	if _, err := dst.Write(rawHeader); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(src, int(header.chunkSize)+1)
+	plaintext := make([]byte, header.chunkSize)
+	sealed := make([]byte, 0, int(header.chunkSize)+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, plaintext)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		final := n < len(plaintext)
+		if !final {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				final = true
+			}
+		}
+
+		sealed = aead.Seal(sealed[:0], chunkNonce(header.noncePrefix, counter, final), plaintext[:n], rawHeader)
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		if counter == ^uint32(0) {
+			return errors.New("input too large to encrypt")
+		}
+	}
+}
+
+func decryptChunks(dst io.Writer, reader *bufio.Reader, key []byte, header *encryptionHeader, rawHeader []byte) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	sealed := make([]byte, int(header.chunkSize)+aead.Overhead())
+	plaintext := make([]byte, 0, header.chunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, sealed)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				return ErrTruncatedCiphertext
+			}
+			return err
+		}
+
+		final := n < len(sealed)
+		if !final {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				final = true
+			}
+		}
+
+		plaintext, err = aead.Open(plaintext[:0], chunkNonce(header.noncePrefix, counter, final), sealed[:n], rawHeader)
+		if err != nil {
+			if final {
+				// A chunk that only opens as a non-final chunk means the stream was cut short.
+				if _, openErr := aead.Open(nil, chunkNonce(header.noncePrefix, counter, false), sealed[:n], rawHeader); openErr == nil {
+					return ErrTruncatedCiphertext
+				}
+			}
+			return ErrDecryptionFailed
+		}
+		if _, err := dst.Write(plaintext); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// encryptFileWith encrypts inputPath into outputPath using the given key and header.
+func encryptFileWith(inputPath, outputPath string, key []byte, header *encryptionHeader) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	if err := encryptChunks(outputFile, inputFile, key, header); err != nil {
+		return err
+	}
+	return outputFile.Close()
+}
+
+// decryptFileWith opens an encrypted file, reads its header and lets keyFor pick
+// the key from it. The output file is removed if decryption fails.
+func decryptFileWith(inputPath, outputPath string, options DecryptOptions, legacyKey []byte,
+	keyFor func(header *encryptionHeader) ([]byte, error)) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	reader := bufio.NewReader(inputFile)
+	magic, err := reader.Peek(len(encryptionMagic))
+	legacy := err != nil || string(magic) != encryptionMagic
+	if legacy && !options.AllowLegacyCFB {
+		return ErrLegacyCiphertext
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	if legacy {
+		err = decryptLegacyCFB(outputFile, reader, legacyKey)
+	} else {
+		err = decryptHeaderAndChunks(outputFile, reader, keyFor)
+	}
+	if err == nil {
+		err = outputFile.Close()
+	}
+	if err != nil {
+		outputFile.Close()
+		os.Remove(outputPath)
+	}
+	return err
+}
+
+func decryptHeaderAndChunks(dst io.Writer, reader *bufio.Reader, keyFor func(header *encryptionHeader) ([]byte, error)) error {
+	if _, err := reader.Discard(len(encryptionMagic)); err != nil {
+		return err
+	}
+	header, rawHeader, err := readEncryptionHeader(reader)
+	if err != nil {
+		return err
+	}
+	key, err := keyFor(header)
+	if err != nil {
+		return err
+	}
+	return decryptChunks(dst, reader, key, header, rawHeader)
+}
+
+// decryptLegacyCFB decrypts the original mftkit format: a random IV followed by AES-CFB ciphertext.
+func decryptLegacyCFB(dst io.Writer, src io.Reader, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(src, iv); err != nil {
+		return err
+	}
+
+	stream := cipher.NewCFBDecrypter(block, iv)
+	reader := &cipher.StreamReader{S: stream, R: src}
+
+	_, err = io.Copy(dst, reader)
+	return err
+}
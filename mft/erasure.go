@@ -0,0 +1,336 @@
+package mft
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/klauspost/reedsolomon"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrTooFewShards is returned when more shards of an erasure-coded split are
+// missing or damaged than its parity shards can rebuild.
+var ErrTooFewShards = errors.New("too few intact shards to rebuild the file")
+
+// SplitFileErasure splits a file into dataShards data shards and parityShards
+// Reed-Solomon parity shards named filePath.part0, filePath.part1 and so on,
+// and writes a manifest like SplitFileWithManifest. Any dataShards of the
+// dataShards+parityShards parts are enough for MergeFromManifest to rebuild the
+// file, and RepairShards regenerates the others.
+func (m *MFT) SplitFileErasure(filePath string, dataShards, parityShards int, manifestPath string) (*SplitManifest, error) {
+	encoder, err := reedsolomon.NewStream(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	if manifestPath == "" {
+		manifestPath = filePath + ManifestSuffix
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, errors.New("cannot erasure-code an empty file")
+	}
+
+	manifestDir := filepath.Dir(manifestPath)
+	manifest := &SplitManifest{
+		Name:         filepath.Base(filePath),
+		Size:         info.Size(),
+		PartSize:     (info.Size() + int64(dataShards) - 1) / int64(dataShards),
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+	}
+	partPaths := make([]string, dataShards+parityShards)
+	for i := range partPaths {
+		partPaths[i] = filePath + ".part" + strconv.Itoa(i)
+		name, err := filepath.Rel(manifestDir, partPaths[i])
+		if err != nil {
+			return nil, err
+		}
+		name = filepath.ToSlash(name)
+		if err := checkSplitPartName(name); err != nil {
+			return nil, err
+		}
+		manifest.Parts = append(manifest.Parts, SplitPart{Index: i, Name: name, Size: manifest.PartSize})
+	}
+
+	inputFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+
+	total := sha256.New()
+	err = withShardFiles(partPaths[:dataShards], manifest.Parts[:dataShards], func(writers []io.Writer) error {
+		return encoder.Split(io.TeeReader(inputFile, total), writers, manifest.Size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifest.SHA256 = hex.EncodeToString(total.Sum(nil))
+
+	readers, closeReaders, err := openShards(partPaths[:dataShards])
+	if err != nil {
+		return nil, err
+	}
+	defer closeReaders()
+	err = withShardFiles(partPaths[dataShards:], manifest.Parts[dataShards:], func(writers []io.Writer) error {
+		return encoder.Encode(readers, writers)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeSplitManifest(manifestPath, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// RepairShards checks every shard of an erasure-coded split against the
+// manifest and regenerates the missing and damaged ones from the others. It
+// returns the names of the shards it rewrote.
+func (m *MFT) RepairShards(manifestPath string) ([]string, error) {
+	manifest, err := m.ReadSplitManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.DataShards == 0 {
+		return nil, errors.New("manifest is not an erasure-coded split")
+	}
+	partPaths := splitPartPaths(manifestPath, manifest)
+	bad, err := checkShards(manifest, partPaths)
+	if err != nil || len(bad) == 0 {
+		return nil, err
+	}
+
+	// Rebuilt shards are written next to the originals and renamed into place
+	// once they match the manifest.
+	tempPaths := make(map[int]string, len(bad))
+	for _, index := range bad {
+		tempPaths[index] = partPaths[index] + ".tmp"
+	}
+	defer func() {
+		for _, tempPath := range tempPaths {
+			os.Remove(tempPath)
+		}
+	}()
+	if err := reconstructShards(manifest, partPaths, bad, tempPaths); err != nil {
+		return nil, err
+	}
+
+	var repaired []string
+	for _, index := range bad {
+		part := manifest.Parts[index]
+		actual, err := copySplitPart(io.Discard, tempPaths[index])
+		if err != nil {
+			return repaired, err
+		}
+		if actual.Size != part.Size || actual.SHA256 != part.SHA256 {
+			return repaired, fmt.Errorf("%w: rebuilt %s does not match the manifest", ErrPartCorrupt, part.Name)
+		}
+		if err := os.Rename(tempPaths[index], partPaths[index]); err != nil {
+			return repaired, err
+		}
+		delete(tempPaths, index)
+		repaired = append(repaired, part.Name)
+	}
+	return repaired, nil
+}
+
+// mergeShards reassembles an erasure-coded split into outputPath, rebuilding
+// missing or damaged data shards in temporary files without changing the parts.
+func (m *MFT) mergeShards(manifest *SplitManifest, partPaths []string, outputPath string) error {
+	encoder, err := reedsolomon.NewStream(manifest.DataShards, manifest.ParityShards)
+	if err != nil {
+		return err
+	}
+	bad, err := checkShards(manifest, partPaths)
+	if err != nil {
+		return err
+	}
+
+	dataPaths := append([]string(nil), partPaths[:manifest.DataShards]...)
+	tempPaths := make(map[int]string)
+	defer func() {
+		for _, tempPath := range tempPaths {
+			os.Remove(tempPath)
+		}
+	}()
+	for _, index := range bad {
+		if index < manifest.DataShards {
+			tempPaths[index] = outputPath + ".shard" + strconv.Itoa(index) + ".tmp"
+			dataPaths[index] = tempPaths[index]
+		}
+	}
+	if len(tempPaths) > 0 {
+		if err := reconstructShards(manifest, partPaths, bad, tempPaths); err != nil {
+			return err
+		}
+	}
+
+	readers, closeReaders, err := openShards(dataPaths)
+	if err != nil {
+		return err
+	}
+	defer closeReaders()
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	total := sha256.New()
+	if err := encoder.Join(io.MultiWriter(outputFile, total), readers, manifest.Size); err != nil {
+		return err
+	}
+	if err := outputFile.Close(); err != nil {
+		return err
+	}
+	if hex.EncodeToString(total.Sum(nil)) != manifest.SHA256 {
+		return ErrMergeChecksum
+	}
+	return nil
+}
+
+// checkShards returns the indexes of shards that are missing or do not match the
+// manifest, or ErrTooFewShards if there are more than the parity can rebuild.
+func checkShards(manifest *SplitManifest, partPaths []string) ([]int, error) {
+	if len(manifest.Parts) != manifest.DataShards+manifest.ParityShards {
+		return nil, errors.New("manifest does not list every shard")
+	}
+
+	var bad []int
+	var problems []string
+	for i, part := range manifest.Parts {
+		actual, err := copySplitPart(io.Discard, partPaths[i])
+		switch {
+		case os.IsNotExist(err):
+			problems = append(problems, "missing "+part.Name)
+		case err != nil:
+			return nil, err
+		case actual.Size != part.Size || actual.SHA256 != part.SHA256:
+			problems = append(problems, "corrupt "+part.Name)
+		default:
+			continue
+		}
+		bad = append(bad, i)
+	}
+	if len(bad) > manifest.ParityShards {
+		return nil, fmt.Errorf("%w: %s", ErrTooFewShards, strings.Join(problems, ", "))
+	}
+	return bad, nil
+}
+
+// reconstructShards rebuilds the shards whose indexes are keys of fillPaths into
+// those files from the shards not listed in bad.
+func reconstructShards(manifest *SplitManifest, partPaths []string, bad []int, fillPaths map[int]string) error {
+	encoder, err := reedsolomon.NewStream(manifest.DataShards, manifest.ParityShards)
+	if err != nil {
+		return err
+	}
+
+	skip := make(map[int]bool, len(bad))
+	for _, index := range bad {
+		skip[index] = true
+	}
+	valid := make([]io.Reader, len(partPaths))
+	fill := make([]io.Writer, len(partPaths))
+	var filled []*os.File
+	for i, partPath := range partPaths {
+		if fillPath, rebuild := fillPaths[i]; rebuild {
+			file, err := os.Create(fillPath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			fill[i] = file
+			filled = append(filled, file)
+			continue
+		}
+		if skip[i] {
+			continue
+		}
+		file, err := os.Open(partPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		valid[i] = file
+	}
+	if err := encoder.Reconstruct(valid, fill); err != nil {
+		return err
+	}
+	for _, file := range filled {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withShardFiles creates the shard files at paths, passes writers for them to
+// write, and records the SHA-256 digest of each in parts.
+func withShardFiles(paths []string, parts []SplitPart, write func([]io.Writer) error) error {
+	writers := make([]io.Writer, len(paths))
+	files := make([]*os.File, len(paths))
+	hashes := make([]hash.Hash, len(paths))
+	for i, path := range paths {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		files[i] = file
+		hashes[i] = sha256.New()
+		writers[i] = io.MultiWriter(file, hashes[i])
+	}
+	if err := write(writers); err != nil {
+		return err
+	}
+	for i, file := range files {
+		if err := file.Close(); err != nil {
+			return err
+		}
+		parts[i].SHA256 = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return nil
+}
+
+// openShards opens the files at paths and returns a function that closes them.
+func openShards(paths []string) ([]io.Reader, func(), error) {
+	var files []*os.File
+	closeAll := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+	readers := make([]io.Reader, len(paths))
+	for i, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		files = append(files, file)
+		readers[i] = file
+	}
+	return readers, closeAll, nil
+}
+
+// splitPartPaths returns the paths of the parts of manifest, which are relative
+// to the directory of manifestPath.
+func splitPartPaths(manifestPath string, manifest *SplitManifest) []string {
+	partPaths := make([]string, len(manifest.Parts))
+	for i, part := range manifest.Parts {
+		partPaths[i] = filepath.Join(filepath.Dir(manifestPath), filepath.FromSlash(part.Name))
+	}
+	return partPaths
+}
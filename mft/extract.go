@@ -0,0 +1,344 @@
+package mft
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Limits applied by ExtractArchive and UnarchiveFile when the corresponding
+// ExtractOptions field is zero.
+const (
+	DefaultMaxArchiveEntries   = 100000
+	DefaultMaxArchiveSize      = 16 << 30
+	DefaultMaxCompressionRatio = 200
+
+	// ratioCheckThreshold is the amount of extracted data below which the
+	// compression ratio is not checked, so small, highly compressible files pass.
+	ratioCheckThreshold = 1 << 20
+)
+
+var (
+	// ErrUnsafePath is returned for archive entries whose path leaves the destination directory.
+	ErrUnsafePath = errors.New("entry path escapes the destination directory")
+	// ErrAbsolutePath is returned for archive entries with an absolute path.
+	ErrAbsolutePath = errors.New("entry has an absolute path")
+	// ErrUnsafeSymlink is returned for symlinks refused by the symlink policy and for
+	// entries that would be written through a symlink.
+	ErrUnsafeSymlink = errors.New("entry is an unsafe symlink")
+	// ErrTooManyEntries is returned when an archive has more entries than allowed.
+	ErrTooManyEntries = errors.New("archive has too many entries")
+	// ErrArchiveTooLarge is returned when the extracted data exceeds the size limit.
+	ErrArchiveTooLarge = errors.New("archive content exceeds the size limit")
+	// ErrCompressionRatio is returned when data expands more than the allowed compression ratio.
+	ErrCompressionRatio = errors.New("archive exceeds the compression ratio limit")
+)
+
+// ArchiveError reports the archive entry that violated an extraction rule or
+// could not be decrypted. Err is one of the ErrUnsafePath family of errors or,
+// for encrypted zip entries, one of the ErrZipPasswordRequired family.
+type ArchiveError struct {
+	Entry string
+	Err   error
+}
+
+func (e *ArchiveError) Error() string {
+	return "archive entry " + e.Entry + ": " + e.Err.Error()
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// SymlinkPolicy selects how symlink entries are extracted.
+type SymlinkPolicy int
+
+const (
+	// SymlinksConfine creates symlinks whose relative target stays inside the destination.
+	SymlinksConfine SymlinkPolicy = iota
+	// SymlinksRefuse rejects every symlink entry.
+	SymlinksRefuse
+)
+
+// ExtractOptions controls ExtractArchive and UnarchiveFileWithOptions. Zero
+// limits use the Default values above; negative limits disable the check.
+type ExtractOptions struct {
+	// RestoreMetadata applies the permissions and modification times stored in the archive.
+	RestoreMetadata bool
+	// RestoreOwners applies the stored user and group IDs of tar entries, which usually requires root.
+	RestoreOwners bool
+	// Symlinks selects how symlink entries are handled.
+	Symlinks SymlinkPolicy
+	// MaxEntries limits the number of entries.
+	MaxEntries int
+	// MaxTotalSize limits the number of bytes extracted.
+	MaxTotalSize int64
+	// MaxCompressionRatio limits extracted bytes per compressed byte.
+	MaxCompressionRatio float64
+	// Password decrypts WinZip AES entries of zip archives.
+	Password string
+}
+
+// extractor places archive entries below a destination directory and enforces
+// the limits of ExtractOptions.
+type extractor struct {
+	destination string
+	options     ExtractOptions
+	entries     int
+	total       int64
+}
+
+func newExtractor(destinationDir string, options ExtractOptions) (*extractor, error) {
+	destination, err := filepath.Abs(destinationDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(destination, os.ModePerm); err != nil {
+		return nil, err
+	}
+	// A symlinked destination is resolved once, so it is never taken for a
+	// symlink entry and replaced.
+	if destination, err = filepath.EvalSymlinks(destination); err != nil {
+		return nil, err
+	}
+
+	if options.MaxEntries == 0 {
+		options.MaxEntries = DefaultMaxArchiveEntries
+	}
+	if options.MaxTotalSize == 0 {
+		options.MaxTotalSize = DefaultMaxArchiveSize
+	}
+	if options.MaxCompressionRatio == 0 {
+		options.MaxCompressionRatio = DefaultMaxCompressionRatio
+	}
+	return &extractor{destination: destination, options: options}, nil
+}
+
+// entry counts an archive entry and returns its path below the destination.
+func (e *extractor) entry(name string) (string, error) {
+	e.entries++
+	if e.options.MaxEntries > 0 && e.entries > e.options.MaxEntries {
+		return "", &ArchiveError{Entry: name, Err: ErrTooManyEntries}
+	}
+	return e.target(name)
+}
+
+// target validates an entry name and returns its path below the destination.
+// No directory on the way may be a symlink, so nothing is written through one.
+func (e *extractor) target(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(slashed) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", &ArchiveError{Entry: name, Err: ErrAbsolutePath}
+	}
+	cleaned := path.Clean(slashed)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", &ArchiveError{Entry: name, Err: ErrUnsafePath}
+	}
+	if cleaned == "." {
+		return e.destination, nil
+	}
+
+	parts := strings.Split(cleaned, "/")
+	current := e.destination
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", &ArchiveError{Entry: name, Err: ErrUnsafeSymlink}
+		}
+	}
+	return filepath.Join(e.destination, filepath.FromSlash(cleaned)), nil
+}
+
+// mkdir creates a directory entry. Entries such as ./ name the destination
+// itself, which already exists.
+func (e *extractor) mkdir(target string) error {
+	if target == e.destination {
+		return nil
+	}
+	removeSymlink(target)
+	return os.MkdirAll(target, os.ModePerm)
+}
+
+// writeFile copies src into target. ratio reports the extracted and compressed
+// byte counts used for the compression ratio check, given the bytes written to
+// this entry so far.
+func (e *extractor) writeFile(name, target string, src io.Reader, ratio func(written int64) (int64, int64)) error {
+	if target == e.destination {
+		return &ArchiveError{Entry: name, Err: ErrUnsafePath}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	removeSymlink(target)
+
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buffer := make([]byte, 32*1024)
+	var written int64
+	for {
+		n, readErr := src.Read(buffer)
+		if n > 0 {
+			written += int64(n)
+			e.total += int64(n)
+			if e.options.MaxTotalSize > 0 && e.total > e.options.MaxTotalSize {
+				return &ArchiveError{Entry: name, Err: ErrArchiveTooLarge}
+			}
+			if extracted, compressed := ratio(written); e.options.MaxCompressionRatio > 0 && extracted > ratioCheckThreshold {
+				if compressed < 1 {
+					compressed = 1
+				}
+				if float64(extracted)/float64(compressed) > e.options.MaxCompressionRatio {
+					return &ArchiveError{Entry: name, Err: ErrCompressionRatio}
+				}
+			}
+			if _, err := file.Write(buffer[:n]); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	return file.Close()
+}
+
+// symlink creates a symlink entry according to the symlink policy. Like files
+// and hard links, it can never replace the destination itself.
+func (e *extractor) symlink(name, target, linkname string) error {
+	if target == e.destination {
+		return &ArchiveError{Entry: name, Err: ErrUnsafePath}
+	}
+	if e.options.Symlinks == SymlinksRefuse {
+		return &ArchiveError{Entry: name, Err: ErrUnsafeSymlink}
+	}
+	slashed := strings.ReplaceAll(linkname, `\`, "/")
+	if linkname == "" || path.IsAbs(slashed) || filepath.IsAbs(linkname) || filepath.VolumeName(linkname) != "" {
+		return &ArchiveError{Entry: name, Err: ErrUnsafeSymlink}
+	}
+	hops := 0
+	if _, ok := e.resolveLink(filepath.Dir(target), slashed, &hops); !ok {
+		return &ArchiveError{Entry: name, Err: ErrUnsafeSymlink}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	os.Remove(target)
+	return os.Symlink(linkname, target)
+}
+
+// maxLinkHops limits how many symlinks resolveLink follows, like the kernel's
+// ELOOP limit.
+const maxLinkHops = 40
+
+// resolveLink resolves linkname, using forward slashes, relative to dir one
+// component at a time, following symlinks that were already extracted. It
+// reports false if any step leaves the destination, so chains such as
+// a/b/l -> ../.. followed by x -> a/b/l/../.. cannot escape it.
+func (e *extractor) resolveLink(dir, linkname string, hops *int) (string, bool) {
+	current := dir
+	for _, part := range strings.Split(linkname, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+		default:
+			next := filepath.Join(current, part)
+			info, err := os.Lstat(next)
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				current = next
+				break
+			}
+			inner, err := os.Readlink(next)
+			*hops++
+			if err != nil || *hops > maxLinkHops || path.IsAbs(filepath.ToSlash(inner)) || filepath.IsAbs(inner) {
+				return "", false
+			}
+			var ok bool
+			if current, ok = e.resolveLink(current, strings.ReplaceAll(inner, `\`, "/"), hops); !ok {
+				return "", false
+			}
+		}
+		if relPath, err := filepath.Rel(e.destination, current); err != nil || relPath == ".." ||
+			strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			return "", false
+		}
+	}
+	return current, true
+}
+
+// link creates a hard link entry to another file inside the destination.
+func (e *extractor) link(name, target, linkname string) error {
+	if target == e.destination {
+		return &ArchiveError{Entry: name, Err: ErrUnsafePath}
+	}
+	source, err := e.target(linkname)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return &ArchiveError{Entry: name, Err: ErrUnsafeSymlink}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	os.Remove(target)
+	return os.Link(source, target)
+}
+
+// restore applies the mode and modification time of an entry if requested.
+func (e *extractor) restore(target string, mode os.FileMode, accessTime, modTime time.Time) error {
+	if !e.options.RestoreMetadata {
+		return nil
+	}
+	if err := os.Chmod(target, mode.Perm()); err != nil {
+		return err
+	}
+	if accessTime.IsZero() {
+		accessTime = time.Now()
+	}
+	return os.Chtimes(target, accessTime, modTime)
+}
+
+// removeSymlink removes path if it is a symlink, so it is not followed when written.
+func removeSymlink(path string) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		os.Remove(path)
+	}
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r     io.Reader
+	count int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count += int64(n)
+	return n, err
+}
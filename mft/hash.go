@@ -0,0 +1,157 @@
+package mft
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+	"hash"
+	"hash/crc32"
+	"io"
+	"lukechampine.com/blake3"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	hashesMu sync.RWMutex
+	hashes   = map[string]func() hash.Hash{
+		"md5":         md5.New,
+		"sha1":        sha1.New,
+		"sha224":      sha256.New224,
+		"sha256":      sha256.New,
+		"sha384":      sha512.New384,
+		"sha512":      sha512.New,
+		"sha3-256":    sha3.New256,
+		"sha3-512":    sha3.New512,
+		"blake2b-256": func() hash.Hash { return mustHash(blake2b.New256(nil)) },
+		"blake2b-512": func() hash.Hash { return mustHash(blake2b.New512(nil)) },
+		"blake3":      func() hash.Hash { return blake3.New(32, nil) },
+		"crc32c":      func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+		"xxh64":       func() hash.Hash { return xxhash.New() },
+	}
+)
+
+// mustHash unwraps the constructors of keyed hashes, which only fail for bad keys.
+func mustHash(h hash.Hash, err error) hash.Hash {
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// RegisterHash adds a hash algorithm to the registry or replaces the one with the
+// same name. Names are case-insensitive.
+func (m *MFT) RegisterHash(name string, newHash func() hash.Hash) error {
+	if name == "" || newHash == nil {
+		return errors.New("hash must have a name and a constructor")
+	}
+	hashesMu.Lock()
+	defer hashesMu.Unlock()
+	hashes[strings.ToLower(name)] = newHash
+	return nil
+}
+
+// NewHash returns a new hash for the algorithm registered under name, such as
+// "sha256", "sha3-256", "blake3", "crc32c" or "xxh64".
+func (m *MFT) NewHash(name string) (hash.Hash, error) {
+	return newHash(name)
+}
+
+// Hashes returns the names of the registered hash algorithms.
+func (m *MFT) Hashes() []string {
+	return hashNames()
+}
+
+func hashNames() []string {
+	hashesMu.RLock()
+	defer hashesMu.RUnlock()
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HashFile computes the digests of a file for each of algorithms in a single read
+// and returns them hex-encoded by algorithm name.
+func (m *MFT) HashFile(filePath string, algorithms ...string) (map[string]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return m.HashReader(file, algorithms...)
+}
+
+// HashReader computes the digests of everything read from r for each of
+// algorithms and returns them hex-encoded by algorithm name.
+func (m *MFT) HashReader(r io.Reader, algorithms ...string) (map[string]string, error) {
+	if len(algorithms) == 0 {
+		return nil, errors.New("no hash algorithms given")
+	}
+	hashers := make(map[string]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		if _, duplicate := hashers[algorithm]; duplicate {
+			continue
+		}
+		hasher, err := newHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		hashers[algorithm] = hasher
+		writers = append(writers, hasher)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string, len(hashers))
+	for algorithm, hasher := range hashers {
+		sums[algorithm] = hex.EncodeToString(hasher.Sum(nil))
+	}
+	return sums, nil
+}
+
+// newHash looks up an algorithm, also accepting names without dashes such as
+// "SHA-256" or "sha3256" as they appear in BSD checksum tags.
+func newHash(algorithm string) (hash.Hash, error) {
+	hashesMu.RLock()
+	defer hashesMu.RUnlock()
+	name := strings.ToLower(algorithm)
+	if newHash, exists := hashes[name]; exists {
+		return newHash(), nil
+	}
+	for registered, newHash := range hashes {
+		if strings.ReplaceAll(registered, "-", "") == strings.ReplaceAll(name, "-", "") {
+			return newHash(), nil
+		}
+	}
+	return nil, errors.New("unsupported hash type: " + algorithm)
+}
+
+func hashFile(filePath, algorithm string) (string, error) {
+	hasher, err := newHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,439 @@
+package mft
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyType identifies the kind of material held by a keystore entry.
+type KeyType string
+
+const (
+	// KeyTypeSymmetric is a 256-bit AES key used by EncryptFileWithKeyID.
+	KeyTypeSymmetric KeyType = "symmetric"
+	// KeyTypePGP is an ASCII-armored or binary OpenPGP keyring.
+	KeyTypePGP KeyType = "pgp"
+	// KeyTypeTLS is a PEM certificate chain followed by its PEM private key.
+	KeyTypeTLS KeyType = "tls"
+)
+
+const symmetricKeySize = 32
+
+// maxKeyIDLength leaves room in the header's 16-bit parameter length for the ID
+// length and version that marshalKeyReference adds around the key ID.
+const maxKeyIDLength = 0xffff - 6
+
+var (
+	// ErrKeyNotFound is returned when a keystore has no key or key version with the requested ID.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyExists is returned by AddKey for an ID that is already in use.
+	ErrKeyExists = errors.New("key already exists")
+	// ErrKeyExpired is returned when encrypting with a key whose current version has expired.
+	ErrKeyExpired = errors.New("key has expired")
+	// ErrKeyTypeMismatch is returned when a key is used for the wrong kind of operation.
+	ErrKeyTypeMismatch = errors.New("key has the wrong type")
+)
+
+// KeyVersion is one generation of a key. Rotation appends a new version and
+// keeps the old ones so that existing files can still be decrypted.
+type KeyVersion struct {
+	Version  int       `json:"version"`
+	Material []byte    `json:"material"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires,omitempty"`
+}
+
+// Expired reports whether the version has an expiry that has passed.
+func (v KeyVersion) Expired() bool {
+	return !v.Expires.IsZero() && time.Now().After(v.Expires)
+}
+
+// StoredKey is a keystore entry with its rotation history, oldest version first.
+type StoredKey struct {
+	ID       string       `json:"id"`
+	Type     KeyType      `json:"type"`
+	Partner  string       `json:"partner"`
+	Versions []KeyVersion `json:"versions"`
+}
+
+// Current returns the newest version of the key.
+func (k StoredKey) Current() KeyVersion {
+	return k.Versions[len(k.Versions)-1]
+}
+
+// Keystore holds keys in a file encrypted under a master passphrase. The file
+// uses the passphrase encryption format of EncryptFileWithPassphrase and is
+// rewritten after every change.
+type Keystore struct {
+	path string
+
+	mu     sync.Mutex
+	params *scryptParams
+	key    []byte
+	keys   map[string]*StoredKey
+}
+
+// CreateKeystore creates an empty keystore at path, which must not exist yet.
+func (m *MFT) CreateKeystore(path, passphrase string) (*Keystore, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase cannot be empty")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, errors.New("keystore already exists: " + path)
+	}
+
+	params, err := newScryptParams()
+	if err != nil {
+		return nil, err
+	}
+	key, err := params.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &Keystore{path: path, params: params, key: key, keys: make(map[string]*StoredKey)}
+	if err := store.save(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// OpenKeystore opens the keystore at path. A wrong passphrase returns ErrDecryptionFailed.
+func (m *MFT) OpenKeystore(path, passphrase string) (*Keystore, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	store := &Keystore{path: path}
+	var data bytes.Buffer
+	err = decryptHeaderAndChunks(&data, bufio.NewReader(file), func(header *encryptionHeader) ([]byte, error) {
+		if header.keyMode != keyModeScrypt {
+			return nil, ErrKeyModeMismatch
+		}
+		params, err := parseScryptParams(header.params)
+		if err != nil {
+			return nil, err
+		}
+		store.params = params
+		store.key, err = params.deriveKey(passphrase)
+		return store.key, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*StoredKey
+	if err := json.Unmarshal(data.Bytes(), &keys); err != nil {
+		return nil, err
+	}
+	store.keys = make(map[string]*StoredKey, len(keys))
+	for _, key := range keys {
+		store.keys[key.ID] = key
+	}
+	return store, nil
+}
+
+// AddKey adds a key under a new ID. For KeyTypeSymmetric, a nil material
+// generates a random key. A zero expires means the key does not expire.
+func (k *Keystore) AddKey(id string, keyType KeyType, partner string, material []byte, expires time.Time) error {
+	if id == "" {
+		return errors.New("key ID cannot be empty")
+	}
+	if len(id) > maxKeyIDLength {
+		return errors.New("key ID is too long")
+	}
+	version, err := newKeyVersion(keyType, 1, material, expires)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, exists := k.keys[id]; exists {
+		return ErrKeyExists
+	}
+	k.keys[id] = &StoredKey{ID: id, Type: keyType, Partner: partner, Versions: []KeyVersion{version}}
+	if err := k.save(); err != nil {
+		delete(k.keys, id)
+		return err
+	}
+	return nil
+}
+
+// RotateKey adds a new current version of the key and returns its version number.
+// Earlier versions are kept for decryption.
+func (k *Keystore) RotateKey(id string, material []byte, expires time.Time) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, exists := k.keys[id]
+	if !exists {
+		return 0, ErrKeyNotFound
+	}
+	version, err := newKeyVersion(key.Type, key.Current().Version+1, material, expires)
+	if err != nil {
+		return 0, err
+	}
+	key.Versions = append(key.Versions, version)
+	if err := k.save(); err != nil {
+		key.Versions = key.Versions[:len(key.Versions)-1]
+		return 0, err
+	}
+	return version.Version, nil
+}
+
+// DeleteKey removes a key and all of its versions.
+func (k *Keystore) DeleteKey(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, exists := k.keys[id]
+	if !exists {
+		return ErrKeyNotFound
+	}
+	delete(k.keys, id)
+	if err := k.save(); err != nil {
+		k.keys[id] = key
+		return err
+	}
+	return nil
+}
+
+// GetKey returns a copy of the key with its rotation history.
+func (k *Keystore) GetKey(id string) (StoredKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, exists := k.keys[id]
+	if !exists {
+		return StoredKey{}, ErrKeyNotFound
+	}
+	return copyStoredKey(key), nil
+}
+
+// ListKeys returns copies of all keys ordered by ID.
+func (k *Keystore) ListKeys() []StoredKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := make([]StoredKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, copyStoredKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// PGPKeyRing parses the current version of a PGP key.
+func (k *Keystore) PGPKeyRing(id string) (openpgp.EntityList, error) {
+	version, err := k.currentVersion(id, KeyTypePGP)
+	if err != nil {
+		return nil, err
+	}
+	return readPGPKeyRing(bytes.NewReader(version.Material))
+}
+
+// TLSCertificate parses the current version of a TLS key.
+func (k *Keystore) TLSCertificate(id string) (tls.Certificate, error) {
+	version, err := k.currentVersion(id, KeyTypeTLS)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(version.Material, version.Material)
+}
+
+func (k *Keystore) currentVersion(id string, keyType KeyType) (KeyVersion, error) {
+	key, err := k.GetKey(id)
+	if err != nil {
+		return KeyVersion{}, err
+	}
+	if key.Type != keyType {
+		return KeyVersion{}, ErrKeyTypeMismatch
+	}
+	return key.Current(), nil
+}
+
+// keyVersion returns a specific version of a symmetric key.
+func (k *Keystore) keyVersion(id string, version int) (KeyVersion, error) {
+	key, err := k.GetKey(id)
+	if err != nil {
+		return KeyVersion{}, err
+	}
+	if key.Type != KeyTypeSymmetric {
+		return KeyVersion{}, ErrKeyTypeMismatch
+	}
+	for _, v := range key.Versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return KeyVersion{}, ErrKeyNotFound
+}
+
+// save writes the keystore to a temporary file and renames it over the old one.
+// The caller must hold k.mu and undo its change if save fails, so the keystore
+// in memory always matches the file.
+func (k *Keystore) save() error {
+	keys := make([]*StoredKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	header, err := newEncryptionHeader(keyModeScrypt, k.params.marshal())
+	if err != nil {
+		return err
+	}
+
+	tempPath := k.path + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := encryptChunks(file, bytes.NewReader(data), k.key, header); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, k.path)
+}
+
+func newKeyVersion(keyType KeyType, number int, material []byte, expires time.Time) (KeyVersion, error) {
+	switch keyType {
+	case KeyTypeSymmetric:
+		if material == nil {
+			material = make([]byte, symmetricKeySize)
+			if _, err := io.ReadFull(rand.Reader, material); err != nil {
+				return KeyVersion{}, err
+			}
+		}
+		if len(material) != 16 && len(material) != 24 && len(material) != 32 {
+			return KeyVersion{}, errors.New("symmetric key must be 16, 24 or 32 bytes long")
+		}
+	case KeyTypePGP, KeyTypeTLS:
+		if len(material) == 0 {
+			return KeyVersion{}, errors.New("key material cannot be empty")
+		}
+	default:
+		return KeyVersion{}, errors.New("unknown key type: " + string(keyType))
+	}
+	return KeyVersion{Version: number, Material: append([]byte(nil), material...), Created: time.Now(), Expires: expires}, nil
+}
+
+func copyStoredKey(key *StoredKey) StoredKey {
+	copied := *key
+	copied.Versions = append([]KeyVersion(nil), key.Versions...)
+	return copied
+}
+
+// Files encrypted with a keystore key use key mode keyModeKeystore and record the
+// key in the header:
+//
+//	ID length (2) | key ID | version (4)
+func marshalKeyReference(id string, version int) []byte {
+	data := make([]byte, 0, 6+len(id))
+	data = binary.BigEndian.AppendUint16(data, uint16(len(id)))
+	data = append(data, id...)
+	return binary.BigEndian.AppendUint32(data, uint32(version))
+}
+
+func parseKeyReference(data []byte) (string, int, error) {
+	if len(data) < 2 {
+		return "", 0, ErrDecryptionFailed
+	}
+	idLength := int(binary.BigEndian.Uint16(data))
+	if len(data) != 2+idLength+4 {
+		return "", 0, ErrDecryptionFailed
+	}
+	return string(data[2 : 2+idLength]), int(binary.BigEndian.Uint32(data[2+idLength:])), nil
+}
+
+// EncryptFileWithKeyID encrypts a file like EncryptFile with the current version
+// of a symmetric keystore key. The key ID and version are recorded in the file
+// header.
+func (m *MFT) EncryptFileWithKeyID(inputPath, outputPath string, keystore *Keystore, keyID string) error {
+	version, err := keystore.currentVersion(keyID, KeyTypeSymmetric)
+	if err != nil {
+		return err
+	}
+	if version.Expired() {
+		return ErrKeyExpired
+	}
+	if len(keyID) > maxKeyIDLength {
+		return errors.New("key ID is too long")
+	}
+
+	header, err := newEncryptionHeader(keyModeKeystore, marshalKeyReference(keyID, version.Version))
+	if err != nil {
+		return err
+	}
+	return encryptFileWith(inputPath, outputPath, version.Material, header)
+}
+
+// DecryptFileWithKeystore decrypts a file written by EncryptFileWithKeyID, using
+// the key version recorded in its header even if the key has since been rotated
+// or has expired.
+func (m *MFT) DecryptFileWithKeystore(inputPath, outputPath string, keystore *Keystore) error {
+	return decryptFileWith(inputPath, outputPath, DecryptOptions{}, nil, func(header *encryptionHeader) ([]byte, error) {
+		if header.keyMode != keyModeKeystore {
+			return nil, ErrKeyModeMismatch
+		}
+		id, number, err := parseKeyReference(header.params)
+		if err != nil {
+			return nil, err
+		}
+		version, err := keystore.keyVersion(id, number)
+		if err != nil {
+			return nil, err
+		}
+		return version.Material, nil
+	})
+}
+
+// FileKeyID returns the keystore key ID and version recorded in a file written by EncryptFileWithKeyID.
+func (m *MFT) FileKeyID(filePath string) (string, int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != encryptionMagic {
+		return "", 0, errors.New("not an encrypted file: " + filePath)
+	}
+	header, _, err := readEncryptionHeader(file)
+	if err != nil {
+		return "", 0, err
+	}
+	if header.keyMode != keyModeKeystore {
+		return "", 0, ErrKeyModeMismatch
+	}
+	return parseKeyReference(header.params)
+}
@@ -0,0 +1,249 @@
+package mft
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+)
+
+// DefaultMerkleChunkSize is the chunk size BuildMerkleTree uses when none is given.
+const DefaultMerkleChunkSize = 1 << 20
+
+// MerkleSuffix is appended to a file's path to name its Merkle tree sidecar.
+const MerkleSuffix = ".merkle.json"
+
+const merkleAlgorithm = "sha256"
+
+// ErrMerkleMismatch is returned when two Merkle trees cannot be compared because
+// they use different chunk sizes or algorithms.
+var ErrMerkleMismatch = errors.New("merkle trees use different parameters")
+
+// ByteRange is a range of bytes within a file.
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// MerkleTree holds the SHA-256 hashes of the fixed-size chunks of a file and the
+// root of the binary tree built over them. A leaf is SHA-256(0x00 || chunk) and
+// an inner node SHA-256(0x01 || left || right); a node without a sibling moves
+// up a level unchanged.
+type MerkleTree struct {
+	Algorithm string   `json:"algorithm"`
+	ChunkSize int64    `json:"chunkSize"`
+	Size      int64    `json:"size"`
+	Root      string   `json:"root"`
+	Leaves    []string `json:"leaves"`
+}
+
+// BuildMerkleTree hashes filePath in chunks of chunkSize bytes, or
+// DefaultMerkleChunkSize if chunkSize is not positive.
+func (m *MFT) BuildMerkleTree(filePath string, chunkSize int64) (*MerkleTree, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultMerkleChunkSize
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tree := &MerkleTree{Algorithm: merkleAlgorithm, ChunkSize: chunkSize}
+	var leaves [][]byte
+	for {
+		hasher := sha256.New()
+		hasher.Write([]byte{0})
+		written, err := io.CopyN(hasher, file, chunkSize)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if written > 0 || len(leaves) == 0 {
+			leaves = append(leaves, hasher.Sum(nil))
+			tree.Size += written
+		}
+		if written < chunkSize {
+			break
+		}
+	}
+
+	for _, leaf := range leaves {
+		tree.Leaves = append(tree.Leaves, hex.EncodeToString(leaf))
+	}
+	levels := merkleLevels(leaves)
+	tree.Root = hex.EncodeToString(levels[len(levels)-1][0])
+	return tree, nil
+}
+
+// WriteMerkleSidecar builds the Merkle tree of filePath and writes it to
+// filePath + MerkleSuffix, returning the sidecar's path.
+func (m *MFT) WriteMerkleSidecar(filePath string, chunkSize int64) (string, error) {
+	tree, err := m.BuildMerkleTree(filePath, chunkSize)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	sidecarPath := filePath + MerkleSuffix
+	return sidecarPath, os.WriteFile(sidecarPath, data, 0644)
+}
+
+// ReadMerkleTree reads a sidecar written by WriteMerkleSidecar.
+func (m *MFT) ReadMerkleTree(sidecarPath string) (*MerkleTree, error) {
+	data, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		return nil, err
+	}
+	var tree MerkleTree
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	if tree.Algorithm != merkleAlgorithm || tree.ChunkSize <= 0 {
+		return nil, errors.New("unsupported merkle tree in " + sidecarPath)
+	}
+	return &tree, nil
+}
+
+// CompareMerkleTrees returns the byte ranges of the file described by expected
+// that differ in the copy described by actual, merging adjacent chunks. Equal
+// roots return no ranges without looking at the chunks.
+func (m *MFT) CompareMerkleTrees(expected, actual *MerkleTree) ([]ByteRange, error) {
+	if expected.Algorithm != actual.Algorithm || expected.ChunkSize != actual.ChunkSize {
+		return nil, ErrMerkleMismatch
+	}
+	if expected.Root == actual.Root && expected.Size == actual.Size {
+		return nil, nil
+	}
+	expectedLeaves, err := decodeMerkleLeaves(expected.Leaves)
+	if err != nil {
+		return nil, err
+	}
+	actualLeaves, err := decodeMerkleLeaves(actual.Leaves)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []int
+	if len(expectedLeaves) == len(actualLeaves) {
+		// Same shape: descend only into subtrees whose hashes differ.
+		expectedLevels, actualLevels := merkleLevels(expectedLeaves), merkleLevels(actualLeaves)
+		chunks = merkleDiff(expectedLevels, actualLevels, len(expectedLevels)-1, 0, nil)
+	} else {
+		for i := range expectedLeaves {
+			if i >= len(actualLeaves) || !bytes.Equal(expectedLeaves[i], actualLeaves[i]) {
+				chunks = append(chunks, i)
+			}
+		}
+	}
+
+	var ranges []ByteRange
+	for _, chunk := range chunks {
+		offset := int64(chunk) * expected.ChunkSize
+		length := expected.ChunkSize
+		if offset+length > expected.Size {
+			length = expected.Size - offset
+		}
+		if length <= 0 {
+			continue
+		}
+		if last := len(ranges) - 1; last >= 0 && ranges[last].Offset+ranges[last].Length == offset {
+			ranges[last].Length += length
+		} else {
+			ranges = append(ranges, ByteRange{Offset: offset, Length: length})
+		}
+	}
+	return ranges, nil
+}
+
+// DiffFileWithMerkleTree hashes filePath with the chunk size of expected and
+// returns the byte ranges that differ from it.
+func (m *MFT) DiffFileWithMerkleTree(filePath string, expected *MerkleTree) ([]ByteRange, error) {
+	actual, err := m.BuildMerkleTree(filePath, expected.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return m.CompareMerkleTrees(expected, actual)
+}
+
+// PatchFileRanges copies ranges from source, such as a good copy of the file or
+// a reader for a remote copy, into filePath and truncates filePath to size, so
+// only the differing ranges are transferred.
+func (m *MFT) PatchFileRanges(filePath string, source io.ReaderAt, ranges []ByteRange, size int64) error {
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, byteRange := range ranges {
+		section := io.NewSectionReader(source, byteRange.Offset, byteRange.Length)
+		if _, err := file.Seek(byteRange.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		written, err := io.Copy(file, section)
+		if err != nil {
+			return err
+		}
+		if written != byteRange.Length {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// merkleLevels returns every level of the tree over leaves, from the leaves up
+// to the root.
+func merkleLevels(leaves [][]byte) [][][]byte {
+	levels := [][][]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			hasher := sha256.New()
+			hasher.Write([]byte{1})
+			hasher.Write(level[i])
+			hasher.Write(level[i+1])
+			next = append(next, hasher.Sum(nil))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// merkleDiff appends the indexes of the differing leaves below node index of
+// the level at depth, counted from the leaves.
+func merkleDiff(expected, actual [][][]byte, depth, index int, chunks []int) []int {
+	if bytes.Equal(expected[depth][index], actual[depth][index]) {
+		return chunks
+	}
+	if depth == 0 {
+		return append(chunks, index)
+	}
+	for child := 2 * index; child <= 2*index+1 && child < len(expected[depth-1]); child++ {
+		chunks = merkleDiff(expected, actual, depth-1, child, chunks)
+	}
+	return chunks
+}
+
+func decodeMerkleLeaves(leaves []string) ([][]byte, error) {
+	decoded := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		var err error
+		if decoded[i], err = hex.DecodeString(leaf); err != nil {
+			return nil, err
+		}
+	}
+	return decoded, nil
+}
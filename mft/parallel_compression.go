@@ -0,0 +1,192 @@
+package mft
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const defaultCompressionBlockSize = 4 << 20
+
+// ParallelCompressOptions controls CompressFileParallel.
+type ParallelCompressOptions struct {
+	// Codec is "gzip" (the default) or "zstd". Each block becomes a gzip member
+	// or zstd frame, so the output is a standard stream.
+	Codec string
+	// Level is the compression level, as for CompressFileWith.
+	Level int
+	// BlockSize is the number of input bytes per block; 4 MiB by default.
+	BlockSize int
+	// Workers is the number of blocks compressed at once; runtime.NumCPU() by default.
+	Workers int
+	// Progress, if set, is called after each block is written.
+	Progress func(progress CompressionProgress)
+}
+
+// CompressionProgress reports the state of a parallel compression.
+type CompressionProgress struct {
+	BytesRead    int64
+	BytesWritten int64
+	TotalBytes   int64
+	Elapsed      time.Duration
+	// Throughput is the number of input bytes compressed per second.
+	Throughput float64
+}
+
+// compressionBlock is a block of input and, once compressed, its output.
+type compressionBlock struct {
+	input  []byte
+	output bytes.Buffer
+	err    error
+	done   chan struct{}
+}
+
+// CompressFileParallel compresses a file in independent blocks on a pool of
+// workers and writes them in order as one multi-member gzip or multi-frame zstd
+// stream that DecompressFile and standard tools can read.
+func (m *MFT) CompressFileParallel(inputPath, outputPath string, options ParallelCompressOptions) error {
+	if options.Codec == "" {
+		options.Codec = "gzip"
+	}
+	if options.Codec != "gzip" && options.Codec != "zstd" {
+		return errors.New("parallel compression supports gzip and zstd only")
+	}
+	codec, err := m.GetCodec(options.Codec)
+	if err != nil {
+		return err
+	}
+	if options.Level < DefaultCompression || options.Level > BestCompression {
+		return errors.New("compression level must be between 0 and 9")
+	}
+	if options.BlockSize <= 0 {
+		options.BlockSize = defaultCompressionBlockSize
+	}
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	info, err := inputFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	// Blocks are queued in input order; the queue capacity bounds the number
+	// of blocks held in memory.
+	jobs := make(chan *compressionBlock)
+	queue := make(chan *compressionBlock, options.Workers*2)
+	stop := make(chan struct{})
+
+	var workers sync.WaitGroup
+	for i := 0; i < options.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for block := range jobs {
+				block.err = compressBlock(codec, &block.output, block.input, options.Level)
+				close(block.done)
+			}
+		}()
+	}
+
+	var readErr error
+	go func() {
+		defer close(queue)
+		defer close(jobs)
+		for {
+			input := make([]byte, options.BlockSize)
+			n, err := io.ReadFull(inputFile, input)
+			if n > 0 {
+				block := &compressionBlock{input: input[:n], done: make(chan struct{})}
+				select {
+				case queue <- block:
+				case <-stop:
+					return
+				}
+				jobs <- block
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	progress := CompressionProgress{TotalBytes: info.Size()}
+	var writeErr error
+	for block := range queue {
+		<-block.done
+		if writeErr != nil {
+			continue
+		}
+		if block.err != nil {
+			writeErr = block.err
+		} else if _, err := outputFile.Write(block.output.Bytes()); err != nil {
+			writeErr = err
+		}
+		if writeErr != nil {
+			close(stop)
+			continue
+		}
+
+		progress.BytesRead += int64(len(block.input))
+		progress.BytesWritten += int64(block.output.Len())
+		progress.Elapsed = time.Since(start)
+		if seconds := progress.Elapsed.Seconds(); seconds > 0 {
+			progress.Throughput = float64(progress.BytesRead) / seconds
+		}
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+	}
+	workers.Wait()
+
+	if writeErr != nil {
+		return writeErr
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if progress.BytesRead == 0 {
+		// An empty input still needs one member to be a valid stream.
+		var empty bytes.Buffer
+		if err := compressBlock(codec, &empty, nil, options.Level); err != nil {
+			return err
+		}
+		if _, err := outputFile.Write(empty.Bytes()); err != nil {
+			return err
+		}
+	}
+	return outputFile.Close()
+}
+
+func compressBlock(codec Codec, output *bytes.Buffer, input []byte, level int) error {
+	writer, err := codec.NewWriter(output, level)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(input); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
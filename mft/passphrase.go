@@ -0,0 +1,116 @@
+package mft
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/scrypt"
+	"io"
+)
+
+// Passphrase-encrypted files use key mode keyModeScrypt and store the scrypt
+// parameters in the header:
+//
+//	log2(N) (1) | r (4) | p (4) | salt (16)
+//
+// so that decryption needs only the passphrase.
+const (
+	scryptLogN    = 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptSaltLen = 16
+	scryptKeyLen  = 32
+
+	// Limits applied to parameters read from a header, so a crafted file cannot
+	// make decryption allocate or compute without bound. scrypt allocates about
+	// 128*r*N bytes, which maxScryptMemory caps at 256 MiB.
+	maxScryptLogN   = 22
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptMemory = 256 << 20
+)
+
+// ErrInvalidKDFParams is returned when a file header carries unusable key derivation parameters.
+var ErrInvalidKDFParams = errors.New("invalid key derivation parameters")
+
+type scryptParams struct {
+	logN byte
+	r, p uint32
+	salt []byte
+}
+
+func newScryptParams() (*scryptParams, error) {
+	params := &scryptParams{logN: scryptLogN, r: scryptR, p: scryptP, salt: make([]byte, scryptSaltLen)}
+	if _, err := io.ReadFull(rand.Reader, params.salt); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+func (p *scryptParams) marshal() []byte {
+	data := make([]byte, 0, 9+len(p.salt))
+	data = append(data, p.logN)
+	data = binary.BigEndian.AppendUint32(data, p.r)
+	data = binary.BigEndian.AppendUint32(data, p.p)
+	return append(data, p.salt...)
+}
+
+func parseScryptParams(data []byte) (*scryptParams, error) {
+	if len(data) != 9+scryptSaltLen {
+		return nil, ErrInvalidKDFParams
+	}
+	params := &scryptParams{
+		logN: data[0],
+		r:    binary.BigEndian.Uint32(data[1:5]),
+		p:    binary.BigEndian.Uint32(data[5:9]),
+		salt: data[9:],
+	}
+	if params.logN < 1 || params.logN > maxScryptLogN ||
+		params.r == 0 || params.r > maxScryptR || params.p == 0 || params.p > maxScryptP ||
+		128*uint64(params.r)<<params.logN > maxScryptMemory {
+		return nil, ErrInvalidKDFParams
+	}
+	return params, nil
+}
+
+func (p *scryptParams) deriveKey(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), p.salt, 1<<p.logN, int(p.r), int(p.p), scryptKeyLen)
+}
+
+// EncryptFileWithPassphrase encrypts a file like EncryptFile, deriving a 256-bit
+// key from passphrase with scrypt. The salt and scrypt parameters are stored in
+// the file header.
+func (m *MFT) EncryptFileWithPassphrase(inputPath, outputPath, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("passphrase cannot be empty")
+	}
+
+	params, err := newScryptParams()
+	if err != nil {
+		return err
+	}
+	key, err := params.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	header, err := newEncryptionHeader(keyModeScrypt, params.marshal())
+	if err != nil {
+		return err
+	}
+	return encryptFileWith(inputPath, outputPath, key, header)
+}
+
+// DecryptFileWithPassphrase verifies and decrypts a file written by
+// EncryptFileWithPassphrase. A wrong passphrase returns ErrDecryptionFailed.
+func (m *MFT) DecryptFileWithPassphrase(inputPath, outputPath, passphrase string) error {
+	return decryptFileWith(inputPath, outputPath, DecryptOptions{}, nil, func(header *encryptionHeader) ([]byte, error) {
+		if header.keyMode != keyModeScrypt {
+			return nil, ErrKeyModeMismatch
+		}
+		params, err := parseScryptParams(header.params)
+		if err != nil {
+			return nil, err
+		}
+		return params.deriveKey(passphrase)
+	})
+}
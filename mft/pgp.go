@@ -0,0 +1,304 @@
+package mft
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	pgpMessageType   = "PGP MESSAGE"
+	pgpSignatureType = "PGP SIGNATURE"
+	pgpArmorPrefix   = "-----BEGIN PGP"
+)
+
+var (
+	// ErrPGPSignatureInvalid is returned when a PGP signature does not match the signed data.
+	ErrPGPSignatureInvalid = errors.New("pgp signature is invalid")
+	// ErrPGPUnknownSigner is returned when a PGP signature was made by a key that is not in the keyring.
+	ErrPGPUnknownSigner = errors.New("pgp signature was made by an unknown key")
+	// ErrPGPNotSigned is returned by PGPVerifyFile for messages without a signature.
+	ErrPGPNotSigned = errors.New("pgp message is not signed")
+	// ErrPGPWrongPassphrase is returned when no private key can be unlocked with the passphrase.
+	ErrPGPWrongPassphrase = errors.New("pgp private key passphrase is wrong")
+)
+
+// PGPOptions controls PGPEncryptFile.
+type PGPOptions struct {
+	// Armor writes an ASCII-armored message instead of binary OpenPGP.
+	Armor bool
+	// Signer, if set, signs the message. Its private key must be unlocked.
+	Signer *openpgp.Entity
+}
+
+// LoadPGPKeyRing reads public and private keys from an ASCII-armored or binary keyring file.
+func (m *MFT) LoadPGPKeyRing(path string) (openpgp.EntityList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return m.ReadPGPKeyRing(file)
+}
+
+// ReadPGPKeyRing reads public and private keys in ASCII-armored or binary form.
+func (m *MFT) ReadPGPKeyRing(r io.Reader) (openpgp.EntityList, error) {
+	return readPGPKeyRing(r)
+}
+
+func readPGPKeyRing(r io.Reader) (openpgp.EntityList, error) {
+	reader, armored := detectArmor(r)
+	if armored {
+		return openpgp.ReadArmoredKeyRing(reader)
+	}
+	return openpgp.ReadKeyRing(reader)
+}
+
+// UnlockPGPKeys decrypts the passphrase-protected private keys in keys, so they
+// can be used with PGPOptions.Signer, PGPSignFile and PGPDetachSignFile.
+func (m *MFT) UnlockPGPKeys(keys openpgp.EntityList, passphrase string) error {
+	for _, entity := range keys {
+		if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return ErrPGPWrongPassphrase
+		}
+	}
+	return nil
+}
+
+// PGPEncryptFile encrypts a file to one or more recipients, optionally signing it.
+func (m *MFT) PGPEncryptFile(inputPath, outputPath string, recipients openpgp.EntityList, options PGPOptions) error {
+	if len(recipients) == 0 {
+		return errors.New("at least one recipient is required")
+	}
+	return writePGPMessage(inputPath, outputPath, options.Armor, pgpMessageType, func(w io.Writer, hints *openpgp.FileHints) (io.WriteCloser, error) {
+		return openpgp.Encrypt(w, recipients, options.Signer, hints, nil)
+	})
+}
+
+// PGPDecryptFile decrypts a binary or armored PGP message with a private key from
+// keyring, unlocking it with passphrase if needed. If the message is signed the
+// signature is verified against keyring and the signing entity returned; the
+// returned entity is nil for unsigned messages. The output file is removed if
+// decryption or verification fails.
+func (m *MFT) PGPDecryptFile(inputPath, outputPath string, keyring openpgp.EntityList, passphrase string) (*openpgp.Entity, error) {
+	prompted := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if prompted {
+			return nil, ErrPGPWrongPassphrase
+		}
+		prompted = true
+		for _, key := range keys {
+			if key.PrivateKey != nil && key.PrivateKey.Encrypted {
+				key.PrivateKey.Decrypt([]byte(passphrase))
+			}
+		}
+		return nil, nil
+	}
+	return readPGPMessage(inputPath, outputPath, keyring, prompt, false)
+}
+
+// PGPSignFile writes an inline-signed PGP message containing the input file.
+func (m *MFT) PGPSignFile(inputPath, outputPath string, signer *openpgp.Entity, armored bool) error {
+	return writePGPMessage(inputPath, outputPath, armored, pgpMessageType, func(w io.Writer, hints *openpgp.FileHints) (io.WriteCloser, error) {
+		return openpgp.Sign(w, signer, hints, nil)
+	})
+}
+
+// PGPVerifyFile verifies an inline-signed PGP message and writes its content to
+// outputPath. It returns the signing entity from keyring; the output file is
+// removed if verification fails.
+func (m *MFT) PGPVerifyFile(inputPath, outputPath string, keyring openpgp.EntityList) (*openpgp.Entity, error) {
+	return readPGPMessage(inputPath, outputPath, keyring, nil, true)
+}
+
+// PGPDetachSignFile writes a detached signature for the input file to signaturePath.
+func (m *MFT) PGPDetachSignFile(inputPath, signaturePath string, signer *openpgp.Entity, armored bool) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	signatureFile, err := os.Create(signaturePath)
+	if err != nil {
+		return err
+	}
+	defer signatureFile.Close()
+
+	if armored {
+		err = openpgp.ArmoredDetachSign(signatureFile, signer, inputFile, nil)
+	} else {
+		err = openpgp.DetachSign(signatureFile, signer, inputFile, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return signatureFile.Close()
+}
+
+// PGPVerifyDetachedSignature verifies a binary or armored detached signature of
+// the input file and returns the signing entity from keyring.
+func (m *MFT) PGPVerifyDetachedSignature(inputPath, signaturePath string, keyring openpgp.EntityList) (*openpgp.Entity, error) {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+
+	signatureFile, err := os.Open(signaturePath)
+	if err != nil {
+		return nil, err
+	}
+	defer signatureFile.Close()
+
+	signature, armored := detectArmor(signatureFile)
+	if armored {
+		block, err := armor.Decode(signature)
+		if err != nil {
+			return nil, err
+		}
+		if block.Type != pgpSignatureType {
+			return nil, fmt.Errorf("expected %s block, got: %s", pgpSignatureType, block.Type)
+		}
+		signature = block.Body
+	}
+
+	signer, err := openpgp.CheckDetachedSignature(keyring, bufio.NewReader(inputFile), signature, nil)
+	if err != nil {
+		return nil, pgpSignatureError(err)
+	}
+	return signer, nil
+}
+
+// writePGPMessage streams inputPath through the writer returned by open into
+// outputPath, armoring the output if requested.
+func writePGPMessage(inputPath, outputPath string, armored bool, blockType string,
+	open func(w io.Writer, hints *openpgp.FileHints) (io.WriteCloser, error)) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	info, err := inputFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	var output io.Writer = outputFile
+	var armorWriter io.WriteCloser
+	if armored {
+		armorWriter, err = armor.Encode(outputFile, blockType, nil)
+		if err != nil {
+			return err
+		}
+		output = armorWriter
+	}
+
+	hints := &openpgp.FileHints{IsBinary: true, FileName: filepath.Base(inputPath), ModTime: info.ModTime()}
+	plaintext, err := open(output, hints)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(plaintext, inputFile); err != nil {
+		return err
+	}
+	if err := plaintext.Close(); err != nil {
+		return err
+	}
+	if armorWriter != nil {
+		if err := armorWriter.Close(); err != nil {
+			return err
+		}
+	}
+	return outputFile.Close()
+}
+
+// readPGPMessage streams the literal data of a PGP message into outputPath and
+// checks its signature once the data has been read.
+func readPGPMessage(inputPath, outputPath string, keyring openpgp.EntityList, prompt openpgp.PromptFunction, requireSignature bool) (*openpgp.Entity, error) {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+
+	message, armored := detectArmor(inputFile)
+	if armored {
+		block, err := armor.Decode(message)
+		if err != nil {
+			return nil, err
+		}
+		if block.Type != pgpMessageType {
+			return nil, fmt.Errorf("expected %s block, got: %s", pgpMessageType, block.Type)
+		}
+		message = block.Body
+	}
+
+	details, err := openpgp.ReadMessage(message, keyring, prompt, nil)
+	if err != nil {
+		return nil, err
+	}
+	if requireSignature && !details.IsSigned {
+		return nil, ErrPGPNotSigned
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer outputFile.Close()
+
+	fail := func(err error) (*openpgp.Entity, error) {
+		outputFile.Close()
+		os.Remove(outputPath)
+		return nil, err
+	}
+
+	if _, err := io.Copy(outputFile, details.UnverifiedBody); err != nil {
+		return fail(err)
+	}
+	if details.IsSigned {
+		if details.SignedBy == nil {
+			return fail(ErrPGPUnknownSigner)
+		}
+		if details.SignatureError != nil {
+			return fail(pgpSignatureError(details.SignatureError))
+		}
+	}
+	if err := outputFile.Close(); err != nil {
+		return fail(err)
+	}
+
+	if details.IsSigned {
+		return details.SignedBy.Entity, nil
+	}
+	return nil, nil
+}
+
+func pgpSignatureError(err error) error {
+	if errors.Is(err, pgperrors.ErrUnknownIssuer) {
+		return ErrPGPUnknownSigner
+	}
+	return fmt.Errorf("%w: %v", ErrPGPSignatureInvalid, err)
+}
+
+// detectArmor reports whether r starts with an ASCII armor header. The returned
+// reader yields the complete input.
+func detectArmor(r io.Reader) (io.Reader, bool) {
+	reader := bufio.NewReader(r)
+	prefix, _ := reader.Peek(len(pgpArmorPrefix))
+	return reader, string(prefix) == pgpArmorPrefix
+}
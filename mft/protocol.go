@@ -0,0 +1,116 @@
+package mft
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// protocolMagic prefixes every frame exchanged between mftkit peers.
+const protocolMagic = "MFT1"
+
+// Frame size limits. Request frames are read by a server before anything is
+// known about the peer, so they stay small. Response frames may carry a
+// directory listing and are only read by a client after it sent a request.
+const (
+	maxRequestFrameSize  = 64 * 1024
+	maxResponseFrameSize = 4 << 20
+)
+
+// Operations carried in a TransferHeader.
+const (
+	OpUpload   = "UPLOAD"
+	OpDownload = "DOWNLOAD"
+	OpList     = "LIST"
+	OpStat     = "STAT"
+	OpDelete   = "DELETE"
+)
+
+// Statuses carried in a TransferStatus.
+const (
+	StatusOK    = "OK"
+	StatusError = "ERROR"
+)
+
+// ErrChecksumMismatch is returned when transferred data does not match the announced
+// checksum, and by VerifyChecksumManifest when an entry failed or is missing.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// TransferHeader is the request frame a client sends before any file data. For a
+// download, Offset and Checksum describe partial data the client already holds.
+type TransferHeader struct {
+	Op       string `json:"op"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+}
+
+// TransferStatus is the response frame a server sends for a request. Offset is the
+// position from which file data will be sent (download) or is expected (upload).
+type TransferStatus struct {
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	// Entries holds the file names returned for OpList.
+	Entries []string `json:"entries,omitempty"`
+	// Metadata holds the file metadata returned for OpStat.
+	Metadata *FileMetadata `json:"metadata,omitempty"`
+}
+
+// Err converts an error status into a Go error.
+func (s TransferStatus) Err() error {
+	if s.Status == StatusOK {
+		return nil
+	}
+	if s.Message == "" {
+		return fmt.Errorf("remote error: %s", s.Status)
+	}
+	return fmt.Errorf("remote error: %s", s.Message)
+}
+
+// writeFrame writes v as a frame: magic, big-endian payload length and JSON payload.
+func writeFrame(w io.Writer, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxResponseFrameSize {
+		return errors.New("frame too large")
+	}
+
+	frame := make([]byte, 0, len(protocolMagic)+4+len(payload))
+	frame = append(frame, protocolMagic...)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	_, err = w.Write(frame)
+	return err
+}
+
+// readFrame reads a single frame of at most limit payload bytes from r and
+// decodes its payload into v.
+func readFrame(r io.Reader, limit uint32, v interface{}) error {
+	prefix := make([]byte, len(protocolMagic)+4)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return err
+	}
+	if string(prefix[:len(protocolMagic)]) != protocolMagic {
+		return errors.New("invalid frame magic")
+	}
+
+	length := binary.BigEndian.Uint32(prefix[len(protocolMagic):])
+	if length > limit {
+		return errors.New("frame too large")
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
@@ -0,0 +1,292 @@
+package mft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+)
+
+// RecordFormat selects how SplitRecords finds record boundaries.
+type RecordFormat int
+
+const (
+	// RecordLines treats every line as a record, as in fixed-width and log files.
+	RecordLines RecordFormat = iota
+	// RecordCSV reads CSV records, whose quoted fields may contain line breaks.
+	RecordCSV
+)
+
+// RecordSplitOptions controls SplitRecords and MergeRecords. A new chunk starts
+// when MaxRecords or MaxBytes would be exceeded or, with SplitByKey, when the
+// key changes; at least one of them must be set.
+type RecordSplitOptions struct {
+	Format RecordFormat
+	// Comma is the CSV field delimiter. Zero means ','.
+	Comma rune
+	// Header marks the first record as a header, repeated at the start of
+	// every chunk and written once by MergeRecords.
+	Header bool
+	// MaxRecords limits the records per chunk, not counting the header.
+	MaxRecords int
+	// MaxBytes limits the size of a chunk, including the header. A record larger
+	// than the budget is written to a chunk of its own.
+	MaxBytes int64
+	// SplitByKey starts a new chunk whenever a record's key differs from the
+	// previous record's, so input grouped by key yields one chunk per key.
+	SplitByKey bool
+	// KeyColumn is the zero-based CSV field that holds the key.
+	KeyColumn int
+	// KeyOffset and KeyLength locate the key in the bytes of a RecordLines record.
+	KeyOffset int
+	KeyLength int
+}
+
+// SplitRecords splits a line-based or CSV file into chunks named
+// filePath.part0, filePath.part1 and so on without cutting a record in half,
+// and returns their paths.
+func (m *MFT) SplitRecords(filePath string, options RecordSplitOptions) ([]string, error) {
+	if options.MaxRecords <= 0 && options.MaxBytes <= 0 && !options.SplitByKey {
+		return nil, errors.New("record split needs MaxRecords, MaxBytes or SplitByKey")
+	}
+	inputFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+
+	records := newRecordReader(inputFile, options.Format)
+	var header []byte
+	if options.Header {
+		if header, err = records.next(); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	var parts []string
+	var chunk *recordChunk
+	defer func() {
+		if chunk != nil {
+			chunk.file.Close()
+		}
+	}()
+	var previousKey string
+	for {
+		record, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		key := ""
+		if options.SplitByKey {
+			if key, err = recordKey(record, options); err != nil {
+				return nil, err
+			}
+		}
+		if chunk != nil && chunk.full(record, key != previousKey, options) {
+			if err := chunk.close(); err != nil {
+				return nil, err
+			}
+			chunk = nil
+		}
+		if chunk == nil {
+			partPath := filePath + ".part" + strconv.Itoa(len(parts))
+			if chunk, err = newRecordChunk(partPath, header); err != nil {
+				return nil, err
+			}
+			parts = append(parts, partPath)
+		}
+		if err := chunk.write(record); err != nil {
+			return nil, err
+		}
+		previousKey = key
+	}
+
+	if chunk == nil && options.Header && header != nil {
+		// A file with only a header still yields one chunk holding it.
+		partPath := filePath + ".part0"
+		if chunk, err = newRecordChunk(partPath, header); err != nil {
+			return nil, err
+		}
+		parts = append(parts, partPath)
+	}
+	if chunk != nil {
+		if err := chunk.close(); err != nil {
+			return nil, err
+		}
+		chunk = nil
+	}
+	return parts, nil
+}
+
+// MergeRecords concatenates chunks written by SplitRecords into outputPath. With
+// options.Header, the header of the first chunk is kept and the same header at
+// the start of the other chunks is dropped.
+func (m *MFT) MergeRecords(parts []string, outputPath string, options RecordSplitOptions) error {
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	writer := bufio.NewWriter(outputFile)
+	var header []byte
+	endsWithNewline := true
+	for i, partPath := range parts {
+		inputFile, err := os.Open(partPath)
+		if err != nil {
+			return err
+		}
+		records := newRecordReader(inputFile, options.Format)
+		for first := true; ; first = false {
+			record, err := records.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				inputFile.Close()
+				return err
+			}
+			if options.Header && first {
+				if i == 0 {
+					header = record
+				} else if bytes.Equal(bytes.TrimRight(record, "\r\n"), bytes.TrimRight(header, "\r\n")) {
+					continue
+				}
+			}
+			// A chunk whose last record has no line break is followed by one.
+			if !endsWithNewline {
+				writer.WriteString("\n")
+			}
+			if _, err := writer.Write(record); err != nil {
+				inputFile.Close()
+				return err
+			}
+			endsWithNewline = bytes.HasSuffix(record, []byte("\n"))
+		}
+		inputFile.Close()
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return outputFile.Close()
+}
+
+// recordReader returns the raw bytes of one record at a time, including its
+// line break, so chunks reproduce the input exactly.
+type recordReader struct {
+	reader *bufio.Reader
+	format RecordFormat
+}
+
+func newRecordReader(r io.Reader, format RecordFormat) *recordReader {
+	return &recordReader{reader: bufio.NewReaderSize(r, 64*1024), format: format}
+}
+
+// next returns the next record, or io.EOF when there are no more.
+func (r *recordReader) next() ([]byte, error) {
+	record, err := r.reader.ReadBytes('\n')
+	if r.format == RecordCSV {
+		// A line break inside a quoted field leaves an odd number of quotes.
+		for err == nil && bytes.Count(record, []byte{'"'})%2 == 1 {
+			var line []byte
+			line, err = r.reader.ReadBytes('\n')
+			record = append(record, line...)
+		}
+	}
+	if err == io.EOF && len(record) > 0 {
+		return record, nil
+	}
+	return record, err
+}
+
+// recordKey extracts the key of a record for SplitByKey.
+func recordKey(record []byte, options RecordSplitOptions) (string, error) {
+	if options.Format == RecordCSV {
+		reader := csv.NewReader(bytes.NewReader(record))
+		if options.Comma != 0 {
+			reader.Comma = options.Comma
+		}
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		fields, err := reader.Read()
+		if err != nil {
+			return "", err
+		}
+		if options.KeyColumn >= len(fields) {
+			return "", nil
+		}
+		return fields[options.KeyColumn], nil
+	}
+
+	line := bytes.TrimRight(record, "\r\n")
+	if options.KeyOffset >= len(line) {
+		return "", nil
+	}
+	end := len(line)
+	if options.KeyLength > 0 && options.KeyOffset+options.KeyLength < end {
+		end = options.KeyOffset + options.KeyLength
+	}
+	return string(line[options.KeyOffset:end]), nil
+}
+
+// recordChunk is one output file of SplitRecords.
+type recordChunk struct {
+	file    *os.File
+	writer  *bufio.Writer
+	records int
+	size    int64
+}
+
+func newRecordChunk(partPath string, header []byte) (*recordChunk, error) {
+	file, err := os.Create(partPath)
+	if err != nil {
+		return nil, err
+	}
+	chunk := &recordChunk{file: file, writer: bufio.NewWriter(file)}
+	if header != nil {
+		if !bytes.HasSuffix(header, []byte("\n")) {
+			header = append(header[:len(header):len(header)], '\n')
+		}
+		if _, err := chunk.writer.Write(header); err != nil {
+			file.Close()
+			return nil, err
+		}
+		chunk.size = int64(len(header))
+	}
+	return chunk, nil
+}
+
+// full reports whether record must start a new chunk.
+func (c *recordChunk) full(record []byte, keyChanged bool, options RecordSplitOptions) bool {
+	if c.records == 0 {
+		return false
+	}
+	return (options.MaxRecords > 0 && c.records >= options.MaxRecords) ||
+		(options.MaxBytes > 0 && c.size+int64(len(record)) > options.MaxBytes) ||
+		(options.SplitByKey && keyChanged)
+}
+
+func (c *recordChunk) write(record []byte) error {
+	if _, err := c.writer.Write(record); err != nil {
+		return err
+	}
+	c.records++
+	c.size += int64(len(record))
+	return nil
+}
+
+func (c *recordChunk) close() error {
+	if err := c.writer.Flush(); err != nil {
+		c.file.Close()
+		return err
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,246 @@
+package mft
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultScanInterval is how often PollTree scans when PollOptions.Interval is
+// zero.
+const DefaultScanInterval = 10 * time.Second
+
+// PollOptions controls PollTree.
+type PollOptions struct {
+	// Interval is the time between the end of one scan and the start of the next.
+	Interval time.Duration
+	// SnapshotPath names a JSON file that keeps the last scan across restarts, so
+	// files that have not changed while the scanner was stopped are not reported
+	// again. Empty keeps the snapshot in memory only.
+	SnapshotPath string
+	// Include and Exclude filter paths as for WatchOptions.
+	Include []string
+	Exclude []string
+	// OnError receives errors that do not stop the scanner, such as a scan of an
+	// unavailable mount or a failure to save the snapshot.
+	OnError func(err error)
+}
+
+// scanEntry is what PollTree remembers about a file between scans.
+type scanEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Inode   uint64    `json:"inode"`
+}
+
+// PollTree reports changes to the regular files below root by comparing
+// successive scans, for network mounts such as NFS and SMB where fsnotify does
+// not see changes made by other hosts. New files are reported as Create,
+// changes to size or modification time as Write, removed files as Remove and a
+// file replaced under the same name as Create. The first scan without a saved
+// snapshot only records the files already present. Callbacks run one at a time
+// and receive the same events as WatchTree's. PollTree returns nil when ctx is
+// cancelled.
+func (m *MFT) PollTree(ctx context.Context, root string, options PollOptions, callback func(event FileEvent)) error {
+	if options.Interval <= 0 {
+		options.Interval = DefaultScanInterval
+	}
+	scanner := &treeScanner{root: root, options: options}
+	if options.SnapshotPath != "" {
+		snapshotAbs, err := filepath.Abs(options.SnapshotPath)
+		if err != nil {
+			return err
+		}
+		scanner.snapshotAbs = snapshotAbs
+	}
+
+	previous, err := scanner.load()
+	if err != nil {
+		return err
+	}
+	wait := time.Duration(0)
+	if previous == nil {
+		if previous, err = scanner.scan(nil); err != nil {
+			return err
+		}
+		scanner.save(previous)
+		wait = options.Interval
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+		current, err := scanner.scan(previous)
+		if err != nil {
+			// A failed scan, such as of a mount that went away, must not be
+			// taken for the removal of every file.
+			scanner.report(err)
+		} else {
+			if changed := scanner.diff(previous, current, callback); changed {
+				scanner.save(current)
+			}
+			previous = current
+		}
+		timer.Reset(options.Interval)
+	}
+}
+
+type treeScanner struct {
+	root        string
+	options     PollOptions
+	snapshotAbs string
+}
+
+// scan returns the regular files below root by path relative to root. Only a
+// failure to read root fails the scan. Other errors, such as a subdirectory of
+// a share that cannot be read, go to OnError, and the files previously seen
+// there are kept, so they are not reported as removed.
+func (s *treeScanner) scan(previous map[string]scanEntry) (map[string]scanEntry, error) {
+	entries := make(map[string]scanEntry)
+	err := filepath.Walk(s.root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if filePath == s.root {
+				return err
+			}
+			if os.IsNotExist(err) {
+				return nil
+			}
+			s.report(err)
+			name := s.relative(filePath)
+			for previousName, entry := range previous {
+				if previousName == name || strings.HasPrefix(previousName, name+"/") {
+					entries[previousName] = entry
+				}
+			}
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filePath == s.root {
+			return nil
+		}
+		name := s.relative(filePath)
+		if matchAny(s.options.Exclude, name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || (len(s.options.Include) > 0 && !matchAny(s.options.Include, name)) {
+			return nil
+		}
+		if s.isSnapshot(filePath) {
+			return nil
+		}
+		entry := scanEntry{Size: info.Size(), ModTime: info.ModTime()}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			entry.Inode = uint64(stat.Ino)
+		}
+		entries[name] = entry
+		return nil
+	})
+	return entries, err
+}
+
+// diff calls callback for every difference between two scans in path order and
+// reports whether there were any.
+func (s *treeScanner) diff(previous, current map[string]scanEntry, callback func(event FileEvent)) bool {
+	var events []FileEvent
+	for name, entry := range current {
+		old, exists := previous[name]
+		switch {
+		case !exists || old.Inode != entry.Inode:
+			events = append(events, FileEvent{Op: fsnotify.Create, Name: name})
+		case old.Size != entry.Size || !old.ModTime.Equal(entry.ModTime):
+			events = append(events, FileEvent{Op: fsnotify.Write, Name: name})
+		}
+	}
+	for name := range previous {
+		if _, exists := current[name]; !exists {
+			events = append(events, FileEvent{Op: fsnotify.Remove, Name: name})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+
+	for _, event := range events {
+		event.Name = filepath.Join(s.root, filepath.FromSlash(event.Name))
+		callback(event)
+	}
+	return len(events) > 0
+}
+
+// load reads the saved snapshot, returning nil if there is none.
+func (s *treeScanner) load() (map[string]scanEntry, error) {
+	if s.options.SnapshotPath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(s.options.SnapshotPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]scanEntry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// save writes the snapshot through a temporary file, so a crash never leaves a
+// truncated one behind.
+func (s *treeScanner) save(entries map[string]scanEntry) {
+	if s.options.SnapshotPath == "" {
+		return
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		s.report(err)
+		return
+	}
+	tempPath := s.options.SnapshotPath + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		s.report(err)
+		return
+	}
+	if err := os.Rename(tempPath, s.options.SnapshotPath); err != nil {
+		s.report(err)
+	}
+}
+
+// isSnapshot reports whether filePath is the snapshot or its temporary file,
+// which may be kept inside the scanned tree.
+func (s *treeScanner) isSnapshot(filePath string) bool {
+	if s.snapshotAbs == "" {
+		return false
+	}
+	absPath, err := filepath.Abs(filePath)
+	return err == nil && (absPath == s.snapshotAbs || absPath == s.snapshotAbs+".tmp")
+}
+
+func (s *treeScanner) relative(filePath string) string {
+	relPath, err := filepath.Rel(s.root, filePath)
+	if err != nil {
+		return filepath.ToSlash(filePath)
+	}
+	return filepath.ToSlash(relPath)
+}
+
+func (s *treeScanner) report(err error) {
+	if s.options.OnError != nil {
+		s.options.OnError(err)
+	}
+}
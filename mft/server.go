@@ -0,0 +1,307 @@
+package mft
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrServerClosed is returned by Server.Serve after Server.Close has been called.
+var ErrServerClosed = errors.New("server closed")
+
+// Server is the receiving end of UploadFile and DownloadFile. It serves files
+// below Root and never allows a request to reach outside of it.
+type Server struct {
+	Root string
+	// TLS, when set, makes the server accept TLS connections only.
+	TLS *TLSOptions
+
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+	active   map[string]bool
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a server that stores and serves files below root.
+func NewServer(root string) *Server {
+	return &Server{Root: root}
+}
+
+// ListenAndServe listens on the TCP address addr and serves requests.
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on listener and handles one request per connection.
+func (s *Server) Serve(listener net.Listener) error {
+	if s.TLS != nil {
+		config, err := s.TLS.serverConfig()
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, config)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// Addr returns the listener's network address, or nil if the server is not serving.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops the listener and waits for in-flight requests to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	listener := s.listener
+	s.mu.Unlock()
+
+	var err error
+	if listener != nil {
+		err = listener.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	var header TransferHeader
+	if err := readFrame(conn, maxRequestFrameSize, &header); err != nil {
+		return
+	}
+
+	var err error
+	switch header.Op {
+	case OpUpload:
+		err = s.handleUpload(conn, header)
+	case OpDownload:
+		err = s.handleDownload(conn, header)
+	case OpList:
+		err = s.handleList(conn, header)
+	case OpStat:
+		err = s.handleStat(conn, header)
+	case OpDelete:
+		err = s.handleDelete(conn, header)
+	default:
+		err = errors.New("unsupported operation: " + header.Op)
+	}
+	if err != nil {
+		writeFrame(conn, TransferStatus{Status: StatusError, Message: err.Error()})
+	}
+}
+
+// resolve maps a request path onto a path below Root. The checkpoint and
+// partial files of uploads in progress cannot be uploaded, downloaded or deleted.
+func (s *Server) resolve(requestPath string) (string, error) {
+	if requestPath == "" {
+		return "", errors.New("empty path")
+	}
+	cleaned := path.Clean("/" + filepath.ToSlash(requestPath))
+	if cleaned == "/" || isTransferState(cleaned) {
+		return "", errors.New("invalid path: " + requestPath)
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(cleaned)), nil
+}
+
+// isTransferState reports whether name is the checkpoint or partial file of an
+// upload in progress.
+func isTransferState(name string) bool {
+	return strings.HasSuffix(name, ".mftpart") || strings.HasSuffix(name, ".mftckpt")
+}
+
+// resolveDir is like resolve but also accepts the root itself.
+func (s *Server) resolveDir(requestPath string) string {
+	cleaned := path.Clean("/" + filepath.ToSlash(requestPath))
+	return filepath.Join(s.Root, filepath.FromSlash(cleaned))
+}
+
+// acquire marks target as being written, so that a retried upload cannot race
+// with a connection that has not noticed its failure yet.
+func (s *Server) acquire(target string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		s.active = make(map[string]bool)
+	}
+	if s.active[target] {
+		return false
+	}
+	s.active[target] = true
+	return true
+}
+
+func (s *Server) release(target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.active, target)
+}
+
+func (s *Server) handleUpload(conn net.Conn, header TransferHeader) error {
+	if header.Size < 0 {
+		return errors.New("invalid size")
+	}
+	target, err := s.resolve(header.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+
+	if !s.acquire(target) {
+		return errors.New("transfer already in progress: " + header.Path)
+	}
+	defer s.release(target)
+
+	writer, err := resumeTransfer(target, header.Size, header.Checksum)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFrame(conn, TransferStatus{Status: StatusOK, Offset: writer.Offset()}); err != nil {
+		writer.abort()
+		return err
+	}
+
+	if _, err := io.CopyN(writer, conn, header.Size-writer.Offset()); err != nil {
+		writer.abort()
+		return err
+	}
+
+	checksum, err := writer.commit(&MFT{})
+	if err != nil {
+		return err
+	}
+
+	return writeFrame(conn, TransferStatus{Status: StatusOK, Size: header.Size, Checksum: checksum})
+}
+
+func (s *Server) handleDownload(conn net.Conn, header TransferHeader) error {
+	source, err := s.resolve(header.Path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New("not a file: " + header.Path)
+	}
+
+	checksum, err := (&MFT{}).CalculateChecksum(source)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	if header.Offset > 0 && header.Offset <= info.Size() && header.Checksum == checksum {
+		offset = header.Offset
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	status := TransferStatus{Status: StatusOK, Size: info.Size(), Checksum: checksum, Offset: offset}
+	if err := writeFrame(conn, status); err != nil {
+		return err
+	}
+	_, err = io.CopyN(conn, file, info.Size()-offset)
+	return err
+}
+
+func (s *Server) handleList(conn net.Conn, header TransferHeader) error {
+	entries, err := os.ReadDir(s.resolveDir(header.Path))
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || isTransferState(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return writeFrame(conn, TransferStatus{Status: StatusOK, Entries: names})
+}
+
+func (s *Server) handleStat(conn net.Conn, header TransferHeader) error {
+	metadata, err := (&MFT{}).GetFileMetadata(s.resolveDir(header.Path))
+	if err != nil {
+		return err
+	}
+	return writeFrame(conn, TransferStatus{Status: StatusOK, Metadata: &metadata})
+}
+
+func (s *Server) handleDelete(conn net.Conn, header TransferHeader) error {
+	target, err := s.resolve(header.Path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New("not a file: " + header.Path)
+	}
+	if err := os.Remove(target); err != nil {
+		return err
+	}
+	return writeFrame(conn, TransferStatus{Status: StatusOK})
+}
@@ -0,0 +1,205 @@
+package mft
+
+import (
+	"errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"net"
+	"os"
+	"path"
+	"time"
+)
+
+// SFTPConfig holds the credentials and host key policy for an SFTP connection.
+type SFTPConfig struct {
+	User     string
+	Password string
+	// PrivateKeyFile is a PEM private key used for public-key authentication.
+	PrivateKeyFile string
+	// Passphrase decrypts PrivateKeyFile when it is protected.
+	Passphrase string
+	// Signer is used for public-key authentication instead of PrivateKeyFile.
+	Signer ssh.Signer
+	// HostKeyFingerprint is the expected server host key in ssh.FingerprintSHA256 form ("SHA256:...").
+	HostKeyFingerprint string
+	// KnownHostsFile verifies the server host key against an OpenSSH known_hosts file.
+	KnownHostsFile string
+	// InsecureIgnoreHostKey accepts any host key. Use it for testing only.
+	InsecureIgnoreHostKey bool
+	Timeout               time.Duration
+}
+
+// ErrHostKeyMismatch is returned when the SFTP server presents an unexpected host key.
+var ErrHostKeyMismatch = errors.New("host key does not match fingerprint")
+
+// SFTPClient transfers files to and from an SFTP server.
+type SFTPClient struct {
+	sshClient  *ssh.Client
+	sftpClient *sftp.Client
+}
+
+// ConnectSFTP opens an SFTP session to addr ("host:port").
+func (m *MFT) ConnectSFTP(addr string, config SFTPConfig) (*SFTPClient, error) {
+	clientConfig, err := config.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	sshClient, err := ssh.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+
+	return &SFTPClient{sshClient: sshClient, sftpClient: sftpClient}, nil
+}
+
+func (c SFTPConfig) clientConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if c.Password != "" {
+		auth = append(auth, ssh.Password(c.Password))
+	}
+
+	signer := c.Signer
+	if signer == nil && c.PrivateKeyFile != "" {
+		data, err := os.ReadFile(c.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if c.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(c.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if signer != nil {
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no SFTP authentication method configured")
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	switch {
+	case c.HostKeyFingerprint != "":
+		hostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != c.HostKeyFingerprint {
+				return ErrHostKeyMismatch
+			}
+			return nil
+		}
+	case c.KnownHostsFile != "":
+		callback, err := knownhosts.New(c.KnownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+		hostKeyCallback = callback
+	case c.InsecureIgnoreHostKey:
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, errors.New("no SFTP host key verification configured")
+	}
+
+	return &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         c.Timeout,
+	}, nil
+}
+
+// UploadFile uploads a local file to destinationPath on the server, creating parent directories.
+func (c *SFTPClient) UploadFile(filePath, destinationPath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := c.sftpClient.MkdirAll(path.Dir(destinationPath)); err != nil {
+		return err
+	}
+
+	remoteFile, err := c.sftpClient.Create(destinationPath)
+	if err != nil {
+		return err
+	}
+	defer remoteFile.Close()
+
+	if _, err := io.Copy(remoteFile, file); err != nil {
+		return err
+	}
+	return remoteFile.Close()
+}
+
+// DownloadFile downloads filePath from the server and stores it at destinationPath.
+func (c *SFTPClient) DownloadFile(filePath, destinationPath string) error {
+	remoteFile, err := c.sftpClient.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer remoteFile.Close()
+
+	file, err := os.Create(destinationPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, remoteFile); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// ListFiles lists all files in a remote directory.
+func (c *SFTPClient) ListFiles(directoryPath string) ([]string, error) {
+	entries, err := c.sftpClient.ReadDir(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileList []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			fileList = append(fileList, entry.Name())
+		}
+	}
+	return fileList, nil
+}
+
+// GetFileMetadata retrieves metadata for a remote file.
+func (c *SFTPClient) GetFileMetadata(filePath string) (FileMetadata, error) {
+	info, err := c.sftpClient.Stat(filePath)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+
+	return FileMetadata{
+		Size:        info.Size(),
+		Permissions: info.Mode(),
+		ModTime:     info.ModTime(),
+		IsDir:       info.IsDir(),
+	}, nil
+}
+
+// DeleteFile deletes a remote file.
+func (c *SFTPClient) DeleteFile(filePath string) error {
+	return c.sftpClient.Remove(filePath)
+}
+
+// Close ends the SFTP session and the underlying SSH connection.
+func (c *SFTPClient) Close() error {
+	c.sftpClient.Close()
+	return c.sshClient.Close()
+}
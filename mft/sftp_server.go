@@ -0,0 +1,392 @@
+package mft
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SFTPServer is an embeddable SFTP server that confines every user to Root.
+type SFTPServer struct {
+	Root    string
+	HostKey ssh.Signer
+	// Passwords maps user names to passwords for password authentication.
+	Passwords map[string]string
+	// AuthorizedKeys maps user names to the public keys they may authenticate with.
+	AuthorizedKeys map[string][]ssh.PublicKey
+
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+}
+
+// NewSFTPServer creates an SFTP server that serves root and identifies itself with hostKey.
+func NewSFTPServer(root string, hostKey ssh.Signer) *SFTPServer {
+	return &SFTPServer{
+		Root:           root,
+		HostKey:        hostKey,
+		Passwords:      make(map[string]string),
+		AuthorizedKeys: make(map[string][]ssh.PublicKey),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves SFTP sessions.
+func (s *SFTPServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts SSH connections on listener.
+func (s *SFTPServer) Serve(listener net.Listener) error {
+	if s.HostKey == nil {
+		listener.Close()
+		return errors.New("SFTP server requires a host key")
+	}
+	config := &ssh.ServerConfig{}
+	if len(s.Passwords) > 0 {
+		config.PasswordCallback = s.checkPassword
+	}
+	if len(s.AuthorizedKeys) > 0 {
+		config.PublicKeyCallback = s.checkPublicKey
+	}
+	config.AddHostKey(s.HostKey)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.track(conn, true)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.track(conn, false)
+			defer conn.Close()
+			s.handleConn(conn, config)
+		}()
+	}
+}
+
+// Addr returns the listener's network address, or nil if the server is not serving.
+func (s *SFTPServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops the listener, disconnects open sessions and waits for them to end.
+func (s *SFTPServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	listener := s.listener
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	var err error
+	if listener != nil {
+		err = listener.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *SFTPServer) track(conn net.Conn, open bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	if open {
+		s.conns[conn] = true
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+func (s *SFTPServer) checkPassword(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	expected, ok := s.Passwords[meta.User()]
+	if ok && subtle.ConstantTimeCompare([]byte(expected), password) == 1 {
+		return &ssh.Permissions{}, nil
+	}
+	return nil, errors.New("invalid credentials")
+}
+
+func (s *SFTPServer) checkPublicKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	for _, authorized := range s.AuthorizedKeys[meta.User()] {
+		if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+			return &ssh.Permissions{}, nil
+		}
+	}
+	return nil, errors.New("invalid credentials")
+}
+
+func (s *SFTPServer) handleConn(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.handleSession(channel, channelRequests)
+	}
+}
+
+// handleSession runs the sftp subsystem on a session channel and refuses everything else.
+func (s *SFTPServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for request := range requests {
+		// The subsystem payload is an SSH string: a uint32 length followed by the name.
+		if request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp" {
+			request.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+
+			handler := newSFTPRootHandler(s.Root)
+			server := sftp.NewRequestServer(channel, sftp.Handlers{
+				FileGet:  handler,
+				FilePut:  handler,
+				FileCmd:  handler,
+				FileList: handler,
+			})
+			server.Serve()
+			server.Close()
+			return
+		}
+		request.Reply(false, nil)
+	}
+}
+
+// sftpRootHandler implements the sftp request handlers on top of a local directory.
+type sftpRootHandler struct {
+	root string
+	// realRoot is root with its symlinks resolved, against which resolved
+	// paths are checked.
+	realRoot string
+}
+
+func newSFTPRootHandler(root string) *sftpRootHandler {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		realRoot = filepath.Clean(root)
+	}
+	return &sftpRootHandler{root: root, realRoot: realRoot}
+}
+
+// resolve maps an SFTP path onto a local path below root. Symlinks already in
+// the tree are resolved, including the last element if followLast is set, and
+// paths that lead outside root, or through a dangling symlink that a create
+// would follow, are refused with os.ErrPermission.
+func (h *sftpRootHandler) resolve(requestPath string, followLast bool) (string, error) {
+	lexical := filepath.Join(h.root, filepath.FromSlash(path.Clean("/"+requestPath)))
+	dir, base := lexical, ""
+	if !followLast && lexical != filepath.Clean(h.root) {
+		dir, base = filepath.Dir(lexical), filepath.Base(lexical)
+	}
+
+	resolved, err := h.realPath(dir)
+	if err != nil {
+		return "", err
+	}
+	if relPath, err := filepath.Rel(h.realRoot, resolved); err != nil || relPath == ".." ||
+		strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", os.ErrPermission
+	}
+	return filepath.Join(resolved, base), nil
+}
+
+// realPath resolves the symlinks of filePath. Trailing elements that do not
+// exist yet are kept as they are.
+func (h *sftpRootHandler) realPath(filePath string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(filePath)
+	if err == nil {
+		return resolved, nil
+	}
+	if _, statErr := os.Lstat(filePath); statErr == nil {
+		// It exists but cannot be resolved: a dangling symlink.
+		return "", os.ErrPermission
+	} else if !os.IsNotExist(statErr) {
+		return "", statErr
+	}
+	parent := filepath.Dir(filePath)
+	if parent == filePath {
+		return "", err
+	}
+	resolvedParent, err := h.realPath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(filePath)), nil
+}
+
+func (h *sftpRootHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	target, err := h.resolve(r.Filepath, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(target)
+}
+
+func (h *sftpRootHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	pflags := r.Pflags()
+	flags := os.O_WRONLY
+	if pflags.Read {
+		flags = os.O_RDWR
+	}
+	if pflags.Creat {
+		flags |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flags |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flags |= os.O_EXCL
+	}
+	target, err := h.resolve(r.Filepath, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(target, flags, 0644)
+}
+
+func (h *sftpRootHandler) Filecmd(r *sftp.Request) error {
+	// Only Setstat acts on what a symlink points to; the other commands act on
+	// the link itself.
+	target, err := h.resolve(r.Filepath, r.Method == "Setstat")
+	if err != nil {
+		return err
+	}
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(r, target)
+	case "Rename":
+		newTarget, err := h.resolve(r.Target, false)
+		if err != nil {
+			return err
+		}
+		return os.Rename(target, newTarget)
+	case "Rmdir", "Remove":
+		return os.Remove(target)
+	case "Mkdir":
+		return os.Mkdir(target, 0755)
+	default:
+		// Links could point outside of the root, so they are not supported.
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+func (h *sftpRootHandler) setstat(r *sftp.Request, target string) error {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+	if flags.Size {
+		if err := os.Truncate(target, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := os.Chmod(target, attrs.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		if err := os.Chtimes(target, attrs.AccessTime(), attrs.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *sftpRootHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	target, err := h.resolve(r.Filepath, r.Method != "Lstat")
+	if err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case "List":
+		entries, err := os.ReadDir(target)
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]os.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			infos = append(infos, info)
+		}
+		return fileInfoLister(infos), nil
+	case "Stat":
+		info, err := os.Stat(target)
+		if err != nil {
+			return nil, err
+		}
+		return fileInfoLister{info}, nil
+	case "Lstat":
+		info, err := os.Lstat(target)
+		if err != nil {
+			return nil, err
+		}
+		return fileInfoLister{info}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// fileInfoLister serves a fixed list of entries through sftp.ListerAt.
+type fileInfoLister []os.FileInfo
+
+func (l fileInfoLister) ListAt(entries []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(entries, l[offset:])
+	if n < len(entries) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,305 @@
+package mft
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const signatureAlgorithm = "ed25519-sha256"
+
+var (
+	// ErrSignatureInvalid is returned when a signature does not verify with the public key.
+	ErrSignatureInvalid = errors.New("signature is invalid")
+	// ErrManifestMismatch is returned when a directory does not match its signed manifest.
+	ErrManifestMismatch = errors.New("directory does not match manifest")
+)
+
+// FileSignature is a detached Ed25519 signature over the SHA-256 digest of a
+// file followed by the Signed time in UTC, formatted as RFC 3339 with
+// nanoseconds (time.RFC3339Nano).
+type FileSignature struct {
+	Algorithm string    `json:"algorithm"`
+	SHA256    string    `json:"sha256"`
+	Signature []byte    `json:"signature"`
+	Signed    time.Time `json:"signed"`
+}
+
+// SignedManifestEntry describes one file of a signed directory manifest.
+type SignedManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// SignedManifest lists every file below a directory with its SHA-256 digest.
+// The signature covers the SHA-256 digest of the JSON encoding of Files followed
+// by the Signed time in UTC, formatted as RFC 3339 with nanoseconds
+// (time.RFC3339Nano).
+type SignedManifest struct {
+	Algorithm string                `json:"algorithm"`
+	Files     []SignedManifestEntry `json:"files"`
+	Signature []byte                `json:"signature"`
+	Signed    time.Time             `json:"signed"`
+}
+
+// GenerateSigningKey creates an Ed25519 key pair and writes the private key as
+// PKCS #8 PEM to privateKeyPath and the public key as PKIX PEM to publicKeyPath.
+func (m *MFT) GenerateSigningKey(privateKeyPath, publicKeyPath string) error {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(privateKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(publicKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644)
+}
+
+// LoadSigningKey reads a PEM-encoded PKCS #8 Ed25519 private key.
+func (m *MFT) LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEMBlock(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 private key: " + path)
+	}
+	return privateKey, nil
+}
+
+// LoadVerifyKey reads a PEM-encoded PKIX Ed25519 public key.
+func (m *MFT) LoadVerifyKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEMBlock(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 public key: " + path)
+	}
+	return publicKey, nil
+}
+
+// SignFile writes a detached signature over the SHA-256 digest of filePath to signaturePath.
+func (m *MFT) SignFile(filePath, signaturePath string, key ed25519.PrivateKey) error {
+	checksum, err := m.CalculateChecksum(filePath)
+	if err != nil {
+		return err
+	}
+	digest, _ := hex.DecodeString(checksum)
+
+	signed := time.Now().UTC()
+	signature := FileSignature{
+		Algorithm: signatureAlgorithm,
+		SHA256:    checksum,
+		Signature: ed25519.Sign(key, withSignedTime(digest, signed)),
+		Signed:    signed,
+	}
+	data, err := json.MarshalIndent(signature, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(signaturePath, data, 0644)
+}
+
+// VerifyFileSignature checks a detached signature written by SignFile. It returns
+// ErrSignatureInvalid if the signature or the file content does not match.
+func (m *MFT) VerifyFileSignature(filePath, signaturePath string, key ed25519.PublicKey) error {
+	data, err := os.ReadFile(signaturePath)
+	if err != nil {
+		return err
+	}
+	var signature FileSignature
+	if err := json.Unmarshal(data, &signature); err != nil {
+		return err
+	}
+	if signature.Algorithm != signatureAlgorithm {
+		return errors.New("unsupported signature algorithm: " + signature.Algorithm)
+	}
+
+	checksum, err := m.CalculateChecksum(filePath)
+	if err != nil {
+		return err
+	}
+	digest, _ := hex.DecodeString(checksum)
+	if checksum != signature.SHA256 || !ed25519.Verify(key, withSignedTime(digest, signature.Signed), signature.Signature) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// SignDirectory writes a signed manifest of every regular file below dir to
+// manifestPath. Paths in the manifest are relative and use forward slashes.
+func (m *MFT) SignDirectory(dir, manifestPath string, key ed25519.PrivateKey) error {
+	files, err := m.manifestEntries(dir, manifestPath)
+	if err != nil {
+		return err
+	}
+	signed := time.Now().UTC()
+	digest, err := manifestDigest(files, signed)
+	if err != nil {
+		return err
+	}
+
+	manifest := SignedManifest{
+		Algorithm: signatureAlgorithm,
+		Files:     files,
+		Signature: ed25519.Sign(key, digest),
+		Signed:    signed,
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath, data, 0644)
+}
+
+// VerifyDirectory checks the signature of a manifest written by SignDirectory and
+// compares it with the current content of dir. A bad signature returns
+// ErrSignatureInvalid; changed, missing or unexpected files return an error
+// wrapping ErrManifestMismatch that names them.
+func (m *MFT) VerifyDirectory(dir, manifestPath string, key ed25519.PublicKey) (*SignedManifest, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var manifest SignedManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Algorithm != signatureAlgorithm {
+		return nil, errors.New("unsupported signature algorithm: " + manifest.Algorithm)
+	}
+
+	digest, err := manifestDigest(manifest.Files, manifest.Signed)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(key, digest, manifest.Signature) {
+		return nil, ErrSignatureInvalid
+	}
+
+	current, err := m.manifestEntries(dir, manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	actual := make(map[string]SignedManifestEntry, len(current))
+	for _, entry := range current {
+		actual[entry.Path] = entry
+	}
+
+	var problems []string
+	for _, expected := range manifest.Files {
+		entry, exists := actual[expected.Path]
+		switch {
+		case !exists:
+			problems = append(problems, "missing "+expected.Path)
+		case entry != expected:
+			problems = append(problems, "changed "+expected.Path)
+		}
+		delete(actual, expected.Path)
+	}
+	for _, entry := range current {
+		if _, unexpected := actual[entry.Path]; unexpected {
+			problems = append(problems, "unexpected "+entry.Path)
+		}
+	}
+	if len(problems) > 0 {
+		return &manifest, fmt.Errorf("%w: %s", ErrManifestMismatch, strings.Join(problems, ", "))
+	}
+	return &manifest, nil
+}
+
+// manifestEntries hashes every regular file below dir except the manifest itself.
+func (m *MFT) manifestEntries(dir, manifestPath string) ([]SignedManifestEntry, error) {
+	manifestAbs, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []SignedManifestEntry
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if abs, err := filepath.Abs(path); err == nil && abs == manifestAbs {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		checksum, err := m.CalculateChecksum(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, SignedManifestEntry{Path: filepath.ToSlash(relPath), Size: info.Size(), SHA256: checksum})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+func manifestDigest(files []SignedManifestEntry, signed time.Time) ([]byte, error) {
+	data, err := json.Marshal(files)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(withSignedTime(data, signed))
+	return digest[:], nil
+}
+
+// withSignedTime appends the signing time to signed data, so the timestamp
+// stored next to a signature cannot be changed without invalidating it.
+func withSignedTime(data []byte, signed time.Time) []byte {
+	return append(append([]byte(nil), data...), signed.UTC().Format(time.RFC3339Nano)...)
+}
+
+func readPEMBlock(path, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("no %s PEM block in %s", blockType, path)
+	}
+	return block.Bytes, nil
+}
@@ -0,0 +1,281 @@
+package mft
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ManifestSuffix is appended to a file's path to name the manifest SplitFile writes.
+const ManifestSuffix = ".manifest.json"
+
+var (
+	// ErrPartMissing is returned when a part listed in a split manifest does not exist.
+	ErrPartMissing = errors.New("split part is missing")
+	// ErrPartCorrupt is returned when a part does not match its size or SHA-256 digest.
+	ErrPartCorrupt = errors.New("split part is corrupt")
+	// ErrPartsReordered is returned when parts hold each other's data, such as
+	// after they were renamed or listed in the wrong order.
+	ErrPartsReordered = errors.New("split parts are out of order")
+	// ErrMergeChecksum is returned when the merged file does not match the
+	// SHA-256 digest of the original.
+	ErrMergeChecksum = errors.New("merged file does not match the original checksum")
+	// ErrUnsafePartName is returned for a part name that is not a plain file name
+	// in the manifest's directory.
+	ErrUnsafePartName = errors.New("split part name is not a file name in the manifest directory")
+)
+
+// SplitPart describes one part of a split file. Name is a file name in the
+// directory of the manifest.
+type SplitPart struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// SplitManifest records how a file was split so MergeFromManifest can verify
+// and reassemble it. DataShards and ParityShards are set for erasure-coded
+// splits, whose first DataShards parts hold the data.
+type SplitManifest struct {
+	Name         string      `json:"name"`
+	Size         int64       `json:"size"`
+	SHA256       string      `json:"sha256"`
+	PartSize     int64       `json:"partSize"`
+	DataShards   int         `json:"dataShards,omitempty"`
+	ParityShards int         `json:"parityShards,omitempty"`
+	Parts        []SplitPart `json:"parts"`
+}
+
+// SplitFileWithManifest splits a file into parts of partSize bytes named
+// filePath.part0, filePath.part1 and so on, and writes a JSON manifest with the
+// size and SHA-256 digest of the file and of each part to manifestPath, or to
+// filePath + ManifestSuffix if manifestPath is empty. The manifest must be in the
+// directory of filePath.
+func (m *MFT) SplitFileWithManifest(filePath string, partSize int64, manifestPath string) (*SplitManifest, error) {
+	if partSize <= 0 {
+		return nil, errors.New("part size must be positive")
+	}
+	if manifestPath == "" {
+		manifestPath = filePath + ManifestSuffix
+	}
+	inputFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+
+	manifestDir := filepath.Dir(manifestPath)
+	manifest := &SplitManifest{Name: filepath.Base(filePath), PartSize: partSize}
+	total := sha256.New()
+	input := io.TeeReader(inputFile, total)
+	for i := 0; ; i++ {
+		partPath := filePath + ".part" + strconv.Itoa(i)
+		name, err := filepath.Rel(manifestDir, partPath)
+		if err != nil {
+			return nil, err
+		}
+		name = filepath.ToSlash(name)
+		if err := checkSplitPartName(name); err != nil {
+			return nil, err
+		}
+		part, err := writeSplitPart(partPath, input, partSize)
+		if err != nil {
+			return nil, err
+		}
+		if part.Size == 0 && i > 0 {
+			os.Remove(partPath)
+			break
+		}
+		part.Index, part.Name = i, name
+		manifest.Parts = append(manifest.Parts, part)
+		manifest.Size += part.Size
+		if part.Size < partSize {
+			break
+		}
+	}
+	manifest.SHA256 = hex.EncodeToString(total.Sum(nil))
+
+	if err := writeSplitManifest(manifestPath, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// writeSplitPart copies up to partSize bytes of src into a new part file.
+func writeSplitPart(partPath string, src io.Reader, partSize int64) (SplitPart, error) {
+	outputFile, err := os.Create(partPath)
+	if err != nil {
+		return SplitPart{}, err
+	}
+	defer outputFile.Close()
+
+	hash := sha256.New()
+	written, err := io.CopyN(io.MultiWriter(outputFile, hash), src, partSize)
+	if err != nil && err != io.EOF {
+		return SplitPart{}, err
+	}
+	if err := outputFile.Close(); err != nil {
+		return SplitPart{}, err
+	}
+	return SplitPart{Size: written, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+func writeSplitManifest(manifestPath string, manifest *SplitManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath, data, 0644)
+}
+
+// ReadSplitManifest reads a manifest written by SplitFileWithManifest.
+func (m *MFT) ReadSplitManifest(manifestPath string) (*SplitManifest, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var manifest SplitManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	for i, part := range manifest.Parts {
+		if part.Index != i {
+			return nil, fmt.Errorf("%w: manifest lists part %d at position %d", ErrPartsReordered, part.Index, i)
+		}
+		if err := checkSplitPartName(part.Name); err != nil {
+			return nil, err
+		}
+	}
+	return &manifest, nil
+}
+
+// checkSplitPartName rejects part names that would resolve outside the
+// manifest's directory, so a hostile manifest cannot make MergeFromManifest
+// read, or RepairShards write, arbitrary files.
+func checkSplitPartName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.IsAbs(name) || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w: %q", ErrUnsafePartName, name)
+	}
+	return nil
+}
+
+// MergeFromManifest reassembles the file described by the manifest at
+// manifestPath into outputPath. Every part is checked against its size and
+// SHA-256 digest and the result against the digest of the original. Missing
+// parts return ErrPartMissing, parts holding another part's data
+// ErrPartsReordered and damaged parts ErrPartCorrupt, each naming the parts
+// involved. Erasure-coded splits are rebuilt from any DataShards intact parts,
+// and fail with ErrTooFewShards if fewer remain. outputPath is removed if
+// verification fails.
+func (m *MFT) MergeFromManifest(manifestPath, outputPath string) error {
+	manifest, err := m.ReadSplitManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	partPaths := splitPartPaths(manifestPath, manifest)
+	if manifest.DataShards > 0 {
+		if err := m.mergeShards(manifest, partPaths, outputPath); err != nil {
+			os.Remove(outputPath)
+			return err
+		}
+		return nil
+	}
+
+	var missing []string
+	for i, part := range manifest.Parts {
+		if _, err := os.Stat(partPaths[i]); os.IsNotExist(err) {
+			missing = append(missing, part.Name)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrPartMissing, strings.Join(missing, ", "))
+	}
+
+	if err := m.mergeParts(manifest, partPaths, outputPath); err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
+
+// mergeParts copies the parts of manifest into outputPath, hashing each part and
+// the whole as it goes, and reports parts that do not match the manifest.
+func (m *MFT) mergeParts(manifest *SplitManifest, partPaths []string, outputPath string) error {
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	total := sha256.New()
+	var mismatched []SplitPart
+	for i, part := range manifest.Parts {
+		actual, err := copySplitPart(io.MultiWriter(outputFile, total), partPaths[i])
+		if err != nil {
+			return err
+		}
+		if actual.Size != part.Size || actual.SHA256 != part.SHA256 {
+			actual.Index, actual.Name = part.Index, part.Name
+			mismatched = append(mismatched, actual)
+		}
+	}
+	if err := outputFile.Close(); err != nil {
+		return err
+	}
+
+	if len(mismatched) > 0 {
+		return splitPartsError(manifest, mismatched)
+	}
+	if hex.EncodeToString(total.Sum(nil)) != manifest.SHA256 {
+		return ErrMergeChecksum
+	}
+	return nil
+}
+
+// copySplitPart copies a part file to dst and returns its size and digest.
+func copySplitPart(dst io.Writer, partPath string) (SplitPart, error) {
+	inputFile, err := os.Open(partPath)
+	if err != nil {
+		return SplitPart{}, err
+	}
+	defer inputFile.Close()
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(dst, hash), inputFile)
+	if err != nil {
+		return SplitPart{}, err
+	}
+	return SplitPart{Size: written, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// splitPartsError describes parts that do not match the manifest. When every
+// one of them holds the data of another listed part, the parts were reordered.
+func splitPartsError(manifest *SplitManifest, mismatched []SplitPart) error {
+	expected := make(map[string]SplitPart, len(manifest.Parts))
+	for _, part := range manifest.Parts {
+		expected[part.SHA256] = part
+	}
+
+	var moved, corrupt []string
+	for _, part := range mismatched {
+		if original, found := expected[part.SHA256]; found && original.Size == part.Size {
+			moved = append(moved, part.Name+" holds "+original.Name)
+		} else {
+			corrupt = append(corrupt, part.Name)
+		}
+	}
+	if len(corrupt) > 0 {
+		return fmt.Errorf("%w: %s", ErrPartCorrupt, strings.Join(corrupt, ", "))
+	}
+	return fmt.Errorf("%w: %s", ErrPartsReordered, strings.Join(moved, ", "))
+}
@@ -0,0 +1,142 @@
+package mft
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+// ArchiveDirectory writes a tar archive of the tree below sourceDir to
+// archivePath. Entries keep their relative paths, modes, modification times,
+// owners and symlinks. compression names a codec such as "gzip" or "zstd" for a
+// tar.gz or tar.zst archive; an empty string writes a plain tar.
+func (m *MFT) ArchiveDirectory(sourceDir, archivePath, compression string) error {
+	archiveFile, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archiveFile.Close()
+
+	builder, err := m.NewTarBuilder(archiveFile, ArchiveBuilderOptions{Compression: compression})
+	if err != nil {
+		return err
+	}
+	if err := builder.AddDirectory(sourceDir, ""); err != nil {
+		return err
+	}
+	if err := builder.Close(); err != nil {
+		return err
+	}
+	return archiveFile.Close()
+}
+
+// ExtractArchive extracts a tar, tar.gz, tar.zst or other compressed tar archive
+// into destinationDir, detecting the compression from the archive's magic bytes.
+// Entries are checked against options: paths that escape destinationDir,
+// absolute paths, symlinks outside the policy and archives over the entry, size
+// or compression ratio limits stop extraction with an *ArchiveError.
+func (m *MFT) ExtractArchive(archivePath, destinationDir string, options ExtractOptions) error {
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archiveFile.Close()
+
+	counter := &countingReader{r: archiveFile}
+	reader, err := m.openTarStream(counter)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	extractor, err := newExtractor(destinationDir, options)
+	if err != nil {
+		return err
+	}
+	ratio := func(written int64) (int64, int64) {
+		return extractor.total, counter.count
+	}
+
+	// Directory metadata is applied last, after their contents have been written.
+	type directory struct {
+		header *tar.Header
+		target string
+	}
+	var directories []directory
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			// Global pax records, such as the commit ID git archive writes,
+			// describe the archive rather than a file.
+			continue
+		}
+
+		target, err := extractor.entry(header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = extractor.mkdir(target)
+			directories = append(directories, directory{header, target})
+		case tar.TypeReg:
+			err = extractor.writeFile(header.Name, target, tarReader, ratio)
+		case tar.TypeSymlink:
+			err = extractor.symlink(header.Name, target, header.Linkname)
+		case tar.TypeLink:
+			err = extractor.link(header.Name, target, header.Linkname)
+		default:
+			err = errors.New("unsupported archive entry type for " + header.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeDir {
+			if err := restoreTarMetadata(extractor, header, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(directories) - 1; i >= 0; i-- {
+		if err := restoreTarMetadata(extractor, directories[i].header, directories[i].target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openTarStream returns the decompressed tar stream of r. A plain tar is
+// recognised by the "ustar" magic at offset 257 of its first header.
+func (m *MFT) openTarStream(r io.Reader) (io.ReadCloser, error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(262)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(header) == 262 && string(header[257:262]) == "ustar" {
+		return io.NopCloser(reader), nil
+	}
+	return m.NewDecompressReader(reader)
+}
+
+func restoreTarMetadata(extractor *extractor, header *tar.Header, target string) error {
+	if extractor.options.RestoreOwners {
+		if err := os.Lchown(target, header.Uid, header.Gid); err != nil {
+			return err
+		}
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	return extractor.restore(target, header.FileInfo().Mode(), header.AccessTime, header.ModTime)
+}
@@ -0,0 +1,169 @@
+package mft
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+// TLSOptions configures TLS for UploadFile, DownloadFile and Server.
+type TLSOptions struct {
+	// CAFile is a PEM bundle used to verify the peer. On a Server it also
+	// enables mutual TLS: clients must present a certificate signed by it.
+	CAFile string
+	// CertFile and KeyFile are the local PEM certificate and key. They are
+	// required on a Server and enable client authentication on a client.
+	CertFile string
+	KeyFile  string
+	// PinnedFingerprints are hex SHA-256 fingerprints of acceptable peer
+	// certificates. When set without CAFile, the pin alone authenticates the peer.
+	PinnedFingerprints []string
+	// MinVersion is the minimum TLS version, such as tls.VersionTLS13. Zero means TLS 1.2.
+	MinVersion uint16
+	// ServerName overrides the host name used to verify the server certificate.
+	ServerName string
+}
+
+// ErrFingerprintMismatch is returned when the peer certificate matches none of the pinned fingerprints.
+var ErrFingerprintMismatch = errors.New("peer certificate does not match pinned fingerprints")
+
+// CertificateFingerprint returns the hex SHA-256 fingerprint of a certificate, as used in PinnedFingerprints.
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetTLSOptions enables TLS for the client transfer functions. Passing nil disables TLS.
+func (m *MFT) SetTLSOptions(options *TLSOptions) error {
+	if options != nil {
+		if _, err := options.clientConfig(""); err != nil {
+			return err
+		}
+	}
+	m.tlsOptions = options
+	return nil
+}
+
+// dial connects to server, using TLS when it has been configured.
+func (m *MFT) dial(server string) (net.Conn, error) {
+	if m.tlsOptions == nil {
+		return net.Dial("tcp", server)
+	}
+
+	config, err := m.tlsOptions.clientConfig(server)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial("tcp", server, config)
+}
+
+// clientConfig builds the TLS configuration used to connect to server.
+func (o *TLSOptions) clientConfig(server string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: o.minVersion(),
+		ServerName: o.ServerName,
+	}
+	if config.ServerName == "" && server != "" {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			host = server
+		}
+		config.ServerName = host
+	}
+
+	if o.CAFile != "" {
+		pool, err := loadCertPool(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	} else if len(o.PinnedFingerprints) > 0 {
+		// The pin replaces chain verification, which lets self-signed peers be trusted explicitly.
+		config.InsecureSkipVerify = true
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(o.PinnedFingerprints) > 0 {
+		config.VerifyConnection = o.verifyPins
+	}
+	return config, nil
+}
+
+// serverConfig builds the TLS configuration used by a Server.
+func (o *TLSOptions) serverConfig() (*tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("server TLS requires a certificate and key")
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:   o.minVersion(),
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if o.CAFile != "" {
+		pool, err := loadCertPool(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	} else if len(o.PinnedFingerprints) > 0 {
+		config.ClientAuth = tls.RequireAnyClientCert
+	}
+
+	if len(o.PinnedFingerprints) > 0 {
+		config.VerifyConnection = o.verifyPins
+	}
+	return config, nil
+}
+
+func (o *TLSOptions) minVersion() uint16 {
+	if o.MinVersion == 0 {
+		return tls.VersionTLS12
+	}
+	return o.MinVersion
+}
+
+// verifyPins accepts the connection only if the peer's leaf certificate is pinned.
+func (o *TLSOptions) verifyPins(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return ErrFingerprintMismatch
+	}
+
+	fingerprint := CertificateFingerprint(state.PeerCertificates[0])
+	for _, pinned := range o.PinnedFingerprints {
+		if strings.ToLower(strings.ReplaceAll(pinned, ":", "")) == fingerprint {
+			return nil
+		}
+	}
+	return ErrFingerprintMismatch
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in " + caFile)
+	}
+	return pool, nil
+}
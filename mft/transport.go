@@ -0,0 +1,155 @@
+package mft
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Transport moves files between the local file system and a remote location
+// identified by a URL. The transport used for a URL is selected by its scheme.
+type Transport interface {
+	// Put uploads the local file to remote.
+	Put(localPath string, remote *url.URL) error
+	// Get downloads remote into the local file.
+	Get(remote *url.URL, localPath string) error
+	// List returns the names of the files in the remote directory.
+	List(remote *url.URL) ([]string, error)
+	// Stat returns metadata for the remote file.
+	Stat(remote *url.URL) (FileMetadata, error)
+	// Delete removes the remote file.
+	Delete(remote *url.URL) error
+}
+
+// ErrUnsupportedOperation is returned by transports that cannot perform an operation.
+var ErrUnsupportedOperation = errors.New("operation not supported by transport")
+
+var (
+	transportsMu sync.RWMutex
+	transports   = make(map[string]Transport)
+)
+
+// RegisterTransport registers a transport for a URL scheme. It takes precedence
+// over the built-in file, tcp, http, https and sftp transports.
+func (m *MFT) RegisterTransport(scheme string, transport Transport) error {
+	if scheme == "" {
+		return errors.New("scheme cannot be empty")
+	}
+	if transport == nil {
+		return errors.New("transport cannot be nil")
+	}
+
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[strings.ToLower(scheme)] = transport
+	return nil
+}
+
+// GetTransport returns the transport registered for a URL scheme.
+func (m *MFT) GetTransport(scheme string) (Transport, error) {
+	scheme = strings.ToLower(scheme)
+
+	transportsMu.RLock()
+	transport, exists := transports[scheme]
+	transportsMu.RUnlock()
+	if exists {
+		return transport, nil
+	}
+
+	switch scheme {
+	case "file":
+		return fileTransport{m: m}, nil
+	case "tcp":
+		return tcpTransport{m: m}, nil
+	case "http", "https":
+		return httpTransport{}, nil
+	case "sftp":
+		return sftpTransport{m: m}, nil
+	}
+	return nil, errors.New("no transport for scheme: " + scheme)
+}
+
+// PutFile uploads a local file to destinationURL, for example
+// "sftp://partner@host/inbound/report.csv" or "tcp://host:9000/inbound/report.csv".
+func (m *MFT) PutFile(filePath, destinationURL string) error {
+	transport, target, err := m.resolveURL(destinationURL)
+	if err != nil {
+		return err
+	}
+	return transport.Put(filePath, target)
+}
+
+// GetFile downloads sourceURL into a local file.
+func (m *MFT) GetFile(sourceURL, destinationPath string) error {
+	transport, source, err := m.resolveURL(sourceURL)
+	if err != nil {
+		return err
+	}
+	return transport.Get(source, destinationPath)
+}
+
+// ListRemoteFiles lists the files in the directory at directoryURL.
+func (m *MFT) ListRemoteFiles(directoryURL string) ([]string, error) {
+	transport, target, err := m.resolveURL(directoryURL)
+	if err != nil {
+		return nil, err
+	}
+	return transport.List(target)
+}
+
+// GetRemoteFileMetadata retrieves metadata for the file at fileURL.
+func (m *MFT) GetRemoteFileMetadata(fileURL string) (FileMetadata, error) {
+	transport, target, err := m.resolveURL(fileURL)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	return transport.Stat(target)
+}
+
+// DeleteRemoteFile deletes the file at fileURL.
+func (m *MFT) DeleteRemoteFile(fileURL string) error {
+	transport, target, err := m.resolveURL(fileURL)
+	if err != nil {
+		return err
+	}
+	return transport.Delete(target)
+}
+
+// resolveURL parses rawURL and finds its transport. A value without a scheme is a local path.
+func (m *MFT) resolveURL(rawURL string) (Transport, *url.URL, error) {
+	target, err := parseTransferURL(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport, err := m.GetTransport(target.Scheme)
+	if err != nil {
+		return nil, nil, err
+	}
+	return transport, target, nil
+}
+
+// isTransferURL reports whether s carries a URL scheme.
+func isTransferURL(s string) bool {
+	return strings.Contains(s, "://")
+}
+
+func parseTransferURL(rawURL string) (*url.URL, error) {
+	if !isTransferURL(rawURL) {
+		return &url.URL{Scheme: "file", Path: filepath.ToSlash(rawURL)}, nil
+	}
+	return url.Parse(rawURL)
+}
+
+// joinURL returns a copy of base with elem appended to its path.
+func joinURL(base *url.URL, elem string) *url.URL {
+	joined := *base
+	joined.Path = path.Join(base.Path, filepath.ToSlash(elem))
+	if joined.Host != "" && !strings.HasPrefix(joined.Path, "/") {
+		joined.Path = "/" + joined.Path
+	}
+	joined.RawPath = ""
+	return &joined
+}
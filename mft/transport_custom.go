@@ -0,0 +1,68 @@
+package mft
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// protocolHandlerTransport adapts a handler added with AddCustomProtocolHandler to
+// Transport. File contents travel in Request.Data and Response.Data; List expects
+// newline-separated names and Stat a JSON encoded FileMetadata in Response.Data.
+type protocolHandlerTransport struct {
+	protocol string
+	handler  func(request Request) (Response, error)
+}
+
+func (t *protocolHandlerTransport) call(operation string, remote *url.URL, data []byte) (Response, error) {
+	return t.handler(Request{Protocol: t.protocol, Operation: operation, Path: remote.Path, Data: data})
+}
+
+func (t *protocolHandlerTransport) Put(filePath string, remote *url.URL) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	_, err = t.call(OpUpload, remote, data)
+	return err
+}
+
+func (t *protocolHandlerTransport) Get(remote *url.URL, filePath string) error {
+	response, err := t.call(OpDownload, remote, nil)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, response.Data, 0644)
+}
+
+func (t *protocolHandlerTransport) List(remote *url.URL) ([]string, error) {
+	response, err := t.call(OpList, remote, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(response.Data), "\n") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (t *protocolHandlerTransport) Stat(remote *url.URL) (FileMetadata, error) {
+	response, err := t.call(OpStat, remote, nil)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+
+	var metadata FileMetadata
+	err = json.Unmarshal(response.Data, &metadata)
+	return metadata, err
+}
+
+func (t *protocolHandlerTransport) Delete(remote *url.URL) error {
+	_, err := t.call(OpDelete, remote, nil)
+	return err
+}
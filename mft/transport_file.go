@@ -0,0 +1,45 @@
+package mft
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// fileTransport implements Transport for file:// URLs on the local file system.
+type fileTransport struct {
+	m *MFT
+}
+
+// localPath converts a file:// URL into a local path. A host other than
+// localhost is kept as the leading path element, so "file://./out" is relative.
+func localPath(target *url.URL) string {
+	if target.Host == "" || target.Host == "localhost" {
+		return filepath.FromSlash(target.Path)
+	}
+	return filepath.FromSlash(target.Host + target.Path)
+}
+
+func (t fileTransport) Put(filePath string, remote *url.URL) error {
+	destination := localPath(remote)
+	if err := os.MkdirAll(filepath.Dir(destination), os.ModePerm); err != nil {
+		return err
+	}
+	return t.m.CopyFile(filePath, destination)
+}
+
+func (t fileTransport) Get(remote *url.URL, filePath string) error {
+	return t.m.CopyFile(localPath(remote), filePath)
+}
+
+func (t fileTransport) List(remote *url.URL) ([]string, error) {
+	return t.m.ListFiles(localPath(remote))
+}
+
+func (t fileTransport) Stat(remote *url.URL) (FileMetadata, error) {
+	return t.m.GetFileMetadata(localPath(remote))
+}
+
+func (t fileTransport) Delete(remote *url.URL) error {
+	return t.m.DeleteFile(localPath(remote))
+}
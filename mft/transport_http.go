@@ -0,0 +1,113 @@
+package mft
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// httpTransport implements Transport for http:// and https:// URLs. Put posts the
+// file as the multipart form field "file", named after the URL's "file" query
+// parameter or else the local file name; Get fetches the URL as is.
+type httpTransport struct{}
+
+func (t httpTransport) Put(filePath string, remote *url.URL) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileName := remote.Query().Get("file")
+	if fileName == "" {
+		fileName = filepath.Base(filePath)
+	}
+
+	body, bodyWriter := io.Pipe()
+	form := multipart.NewWriter(bodyWriter)
+	go func() {
+		part, err := form.CreateFormFile("file", fileName)
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		bodyWriter.CloseWithError(err)
+	}()
+
+	response, err := http.Post(remote.String(), form.FormDataContentType(), body)
+	if err != nil {
+		body.Close()
+		return err
+	}
+	defer response.Body.Close()
+	return checkHTTPResponse(response)
+}
+
+func (t httpTransport) Get(remote *url.URL, filePath string) error {
+	response, err := http.Get(remote.String())
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if err := checkHTTPResponse(response); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, response.Body); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (t httpTransport) List(remote *url.URL) ([]string, error) {
+	return nil, ErrUnsupportedOperation
+}
+
+func (t httpTransport) Stat(remote *url.URL) (FileMetadata, error) {
+	response, err := http.Head(remote.String())
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	defer response.Body.Close()
+	if err := checkHTTPResponse(response); err != nil {
+		return FileMetadata{}, err
+	}
+
+	metadata := FileMetadata{Size: response.ContentLength}
+	if modTime, err := http.ParseTime(response.Header.Get("Last-Modified")); err == nil {
+		metadata.ModTime = modTime
+	}
+	return metadata, nil
+}
+
+func (t httpTransport) Delete(remote *url.URL) error {
+	request, err := http.NewRequest(http.MethodDelete, remote.String(), nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return checkHTTPResponse(response)
+}
+
+func checkHTTPResponse(response *http.Response) error {
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("remote error: %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package mft
+
+import (
+	"net"
+	"net/url"
+)
+
+// SetSFTPConfig sets the defaults for sftp:// URLs. A user name and password in
+// the URL take precedence over the ones in config.
+func (m *MFT) SetSFTPConfig(config SFTPConfig) {
+	m.sftpConfig = config
+}
+
+// sftpTransport implements Transport for sftp:// URLs, opening a session per operation.
+type sftpTransport struct {
+	m *MFT
+}
+
+func (t sftpTransport) connect(remote *url.URL) (*SFTPClient, error) {
+	config := t.m.sftpConfig
+	if remote.User != nil {
+		config.User = remote.User.Username()
+		if password, ok := remote.User.Password(); ok {
+			config.Password = password
+		}
+	}
+
+	addr := remote.Host
+	if remote.Port() == "" {
+		addr = net.JoinHostPort(remote.Hostname(), "22")
+	}
+	return t.m.ConnectSFTP(addr, config)
+}
+
+func (t sftpTransport) Put(filePath string, remote *url.URL) error {
+	client, err := t.connect(remote)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.UploadFile(filePath, remote.Path)
+}
+
+func (t sftpTransport) Get(remote *url.URL, filePath string) error {
+	client, err := t.connect(remote)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.DownloadFile(remote.Path, filePath)
+}
+
+func (t sftpTransport) List(remote *url.URL) ([]string, error) {
+	client, err := t.connect(remote)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.ListFiles(remote.Path)
+}
+
+func (t sftpTransport) Stat(remote *url.URL) (FileMetadata, error) {
+	client, err := t.connect(remote)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	defer client.Close()
+	return client.GetFileMetadata(remote.Path)
+}
+
+func (t sftpTransport) Delete(remote *url.URL) error {
+	client, err := t.connect(remote)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.DeleteFile(remote.Path)
+}
@@ -0,0 +1,62 @@
+package mft
+
+import (
+	"errors"
+	"net/url"
+)
+
+// tcpTransport implements Transport for tcp:// URLs served by an mftkit Server.
+// It honours the options set with SetTLSOptions.
+type tcpTransport struct {
+	m *MFT
+}
+
+func (t tcpTransport) Put(filePath string, remote *url.URL) error {
+	return t.m.uploadTCP(remote.Host, filePath, remote.Path)
+}
+
+func (t tcpTransport) Get(remote *url.URL, filePath string) error {
+	return t.m.downloadTCP(remote.Host, remote.Path, filePath)
+}
+
+func (t tcpTransport) List(remote *url.URL) ([]string, error) {
+	status, err := t.m.requestTCP(remote.Host, TransferHeader{Op: OpList, Path: remote.Path})
+	if err != nil {
+		return nil, err
+	}
+	return status.Entries, nil
+}
+
+func (t tcpTransport) Stat(remote *url.URL) (FileMetadata, error) {
+	status, err := t.m.requestTCP(remote.Host, TransferHeader{Op: OpStat, Path: remote.Path})
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	if status.Metadata == nil {
+		return FileMetadata{}, errors.New("missing metadata in response")
+	}
+	return *status.Metadata, nil
+}
+
+func (t tcpTransport) Delete(remote *url.URL) error {
+	_, err := t.m.requestTCP(remote.Host, TransferHeader{Op: OpDelete, Path: remote.Path})
+	return err
+}
+
+// requestTCP sends a request that carries no file data and returns the server's response.
+func (m *MFT) requestTCP(server string, header TransferHeader) (TransferStatus, error) {
+	var status TransferStatus
+	conn, err := m.dial(server)
+	if err != nil {
+		return status, err
+	}
+	defer conn.Close()
+
+	if err := writeFrame(conn, header); err != nil {
+		return status, err
+	}
+	if err := readFrame(conn, maxResponseFrameSize, &status); err != nil {
+		return status, err
+	}
+	return status, status.Err()
+}
@@ -2,19 +2,12 @@ package mft
 
 import (
 	"archive/zip"
-	"compress/gzip"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/md5"
-	"crypto/rand"
-	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
-	"hash"
 	"io"
 	"io/ioutil"
 	"log"
@@ -30,109 +23,47 @@ import (
 )
 
 type MFT struct {
+	tlsOptions *TLSOptions
+	sftpConfig SFTPConfig
 }
 
 func NewMFT() *MFT {
 	return &MFT{}
 }
 
-// EncryptFile encrypts a file using AES.
+// EncryptFile encrypts a file using AES-GCM in the chunked stream format.
+// The key must be 16, 24 or 32 bytes long.
 func (m *MFT) EncryptFile(inputPath, outputPath, key string) error {
-	inputFile, err := os.Open(inputPath)
-	if err != nil {
-		return err
-	}
-	defer inputFile.Close()
-
-	outputFile, err := os.Create(outputPath)
+	header, err := newEncryptionHeader(keyModeRaw, nil)
 	if err != nil {
 		return err
 	}
-	defer outputFile.Close()
-
-	block, err := aes.NewCipher([]byte(key))
-	if err != nil {
-		return err
-	}
-
-	iv := make([]byte, aes.BlockSize)
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return err
-	}
-
-	stream := cipher.NewCFBEncrypter(block, iv)
-	writer := &cipher.StreamWriter{S: stream, W: outputFile}
-
-	if _, err := outputFile.Write(iv); err != nil {
-		return err
-	}
-
-	if _, err := io.Copy(writer, inputFile); err != nil {
-		return err
-	}
-
-	return nil
+	return encryptFileWith(inputPath, outputPath, []byte(key), header)
 }
 
-// DecryptFile decrypts a file using AES.
+// DecryptFile verifies and decrypts a file written by EncryptFile.
 func (m *MFT) DecryptFile(inputPath, outputPath, key string) error {
-	inputFile, err := os.Open(inputPath)
-	if err != nil {
-		return err
-	}
-	defer inputFile.Close()
-
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		return err
-	}
-	defer outputFile.Close()
-
-	block, err := aes.NewCipher([]byte(key))
-	if err != nil {
-		return err
-	}
-
-	iv := make([]byte, aes.BlockSize)
-	if _, err := io.ReadFull(inputFile, iv); err != nil {
-		return err
-	}
-
-	stream := cipher.NewCFBDecrypter(block, iv)
-	reader := &cipher.StreamReader{S: stream, R: inputFile}
-
-	if _, err := io.Copy(outputFile, reader); err != nil {
-		return err
-	}
+	return m.DecryptFileWithOptions(inputPath, outputPath, key, DecryptOptions{})
+}
 
-	return nil
+// DecryptFileWithOptions decrypts a file like DecryptFile. With AllowLegacyCFB it
+// also accepts files in the unauthenticated AES-CFB format of earlier versions.
+func (m *MFT) DecryptFileWithOptions(inputPath, outputPath, key string, options DecryptOptions) error {
+	return decryptFileWith(inputPath, outputPath, options, []byte(key), func(header *encryptionHeader) ([]byte, error) {
+		if header.keyMode != keyModeRaw {
+			return nil, ErrKeyModeMismatch
+		}
+		return []byte(key), nil
+	})
 }
 
 // CompressFile compresses a file using gzip.
 func (m *MFT) CompressFile(inputPath, outputPath string) error {
-	inputFile, err := os.Open(inputPath)
-	if err != nil {
-		return err
-	}
-	defer inputFile.Close()
-
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		return err
-	}
-	defer outputFile.Close()
-
-	writer := gzip.NewWriter(outputFile)
-	defer writer.Close()
-
-	if _, err := io.Copy(writer, inputFile); err != nil {
-		return err
-	}
-
-	return nil
+	return m.CompressFileWith(inputPath, outputPath, "gzip", DefaultCompression)
 }
 
-// DecompressFile decompresses a gzip file.
+// DecompressFile decompresses a file, detecting its format (gzip, zstd, xz,
+// bzip2, zlib or a registered codec) from its magic bytes.
 func (m *MFT) DecompressFile(inputPath, outputPath string) error {
 	inputFile, err := os.Open(inputPath)
 	if err != nil {
@@ -140,25 +71,27 @@ func (m *MFT) DecompressFile(inputPath, outputPath string) error {
 	}
 	defer inputFile.Close()
 
-	outputFile, err := os.Create(outputPath)
+	reader, err := m.NewDecompressReader(inputFile)
 	if err != nil {
 		return err
 	}
-	defer outputFile.Close()
+	defer reader.Close()
 
-	reader, err := gzip.NewReader(inputFile)
+	outputFile, err := os.Create(outputPath)
 	if err != nil {
 		return err
 	}
-	defer reader.Close()
+	defer outputFile.Close()
 
 	if _, err := io.Copy(outputFile, reader); err != nil {
 		return err
 	}
 
-	return nil
+	return outputFile.Close()
 }
 
+// CalculateChecksum returns the hex-encoded SHA-256 digest of a file. HashFile
+// computes other and several digests at once.
 func (m *MFT) CalculateChecksum(filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -174,43 +107,24 @@ func (m *MFT) CalculateChecksum(filePath string) (string, error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// SplitFile splits a file into parts of the specified size.
+// SplitFile splits a file into parts of the specified size and returns their
+// paths. It also writes a manifest to filePath + ManifestSuffix for
+// MergeFromManifest; see SplitFileWithManifest.
 func (m *MFT) SplitFile(filePath string, partSize int) ([]string, error) {
-	inputFile, err := os.Open(filePath)
+	manifest, err := m.SplitFileWithManifest(filePath, int64(partSize), "")
 	if err != nil {
 		return nil, err
 	}
-	defer inputFile.Close()
-
-	var parts []string
-	buffer := make([]byte, partSize)
-	for i := 0; ; i++ {
-		bytesRead, err := inputFile.Read(buffer)
-		if err != nil && err != io.EOF {
-			return nil, err
-		}
-		if bytesRead == 0 {
-			break
-		}
-
-		partPath := filePath + ".part" + string(i)
-		outputFile, err := os.Create(partPath)
-		if err != nil {
-			return nil, err
-		}
-		defer outputFile.Close()
-
-		if _, err := outputFile.Write(buffer[:bytesRead]); err != nil {
-			return nil, err
-		}
 
-		parts = append(parts, partPath)
+	parts := make([]string, len(manifest.Parts))
+	for i, part := range manifest.Parts {
+		parts[i] = filepath.Join(filepath.Dir(filePath), filepath.FromSlash(part.Name))
 	}
-
 	return parts, nil
 }
 
-// MergeFiles merges multiple file parts into a single file.
+// MergeFiles merges multiple file parts into a single file without verifying
+// them. Use MergeFromManifest for parts written by SplitFile.
 func (m *MFT) MergeFiles(parts []string, outputPath string) error {
 	outputFile, err := os.Create(outputPath)
 	if err != nil {
@@ -219,60 +133,170 @@ func (m *MFT) MergeFiles(parts []string, outputPath string) error {
 	defer outputFile.Close()
 
 	for _, partPath := range parts {
-		inputFile, err := os.Open(partPath)
-		if err != nil {
+		if _, err := copySplitPart(outputFile, partPath); err != nil {
 			return err
 		}
-		defer inputFile.Close()
+	}
 
-		if _, err := io.Copy(outputFile, inputFile); err != nil {
+	return outputFile.Close()
+}
+
+// UploadFile uploads a file to a remote server, which stores it at destinationPath.
+// server is either the "host:port" of an mftkit Server or a URL such as
+// "sftp://partner@host:22", in which case the transport for its scheme is used.
+// An mftkit Server keeps a checkpoint for interrupted uploads, so retrying the
+// same upload only sends the data that has not been received yet.
+func (m *MFT) UploadFile(server, filePath, destinationPath string) error {
+	if isTransferURL(server) {
+		transport, base, err := m.resolveURL(server)
+		if err != nil {
 			return err
 		}
+		return transport.Put(filePath, joinURL(base, destinationPath))
 	}
-
-	return nil
+	return m.uploadTCP(server, filePath, destinationPath)
 }
 
-// UploadFile uploads a file to a remote server.
-func (m *MFT) UploadFile(server, filePath, destinationPath string) error {
-	conn, err := net.Dial("tcp", server)
+// uploadTCP uploads a file to an mftkit Server.
+func (m *MFT) uploadTCP(server, filePath, destinationPath string) error {
+	file, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	defer file.Close()
 
-	file, err := os.Open(filePath)
+	info, err := file.Stat()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	if _, err := io.Copy(conn, file); err != nil {
+	checksum, err := m.CalculateChecksum(filePath)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	conn, err := m.dial(server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	header := TransferHeader{Op: OpUpload, Path: destinationPath, Size: info.Size(), Checksum: checksum}
+	if err := writeFrame(conn, header); err != nil {
+		return err
+	}
+
+	var status TransferStatus
+	if err := readFrame(conn, maxResponseFrameSize, &status); err != nil {
+		return err
+	}
+	if err := status.Err(); err != nil {
+		return err
+	}
+	if status.Offset < 0 || status.Offset > info.Size() {
+		return errors.New("invalid resume offset")
+	}
+
+	if _, err := file.Seek(status.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(conn, file, info.Size()-status.Offset); err != nil {
+		return err
+	}
+
+	if err := readFrame(conn, maxResponseFrameSize, &status); err != nil {
+		return err
+	}
+	return status.Err()
 }
 
-// DownloadFile downloads a file from a remote server.
+// DownloadFile downloads filePath from a remote server and stores it at destinationPath.
+// server is either the "host:port" of an mftkit Server or a URL, as for UploadFile.
+// An interrupted download from an mftkit Server leaves a checkpoint next to
+// destinationPath and is resumed from the last verified offset when it is retried.
 func (m *MFT) DownloadFile(server, filePath, destinationPath string) error {
-	conn, err := net.Dial("tcp", server)
+	if isTransferURL(server) {
+		transport, base, err := m.resolveURL(server)
+		if err != nil {
+			return err
+		}
+		return transport.Get(joinURL(base, filePath), destinationPath)
+	}
+	return m.downloadTCP(server, filePath, destinationPath)
+}
+
+// downloadTCP downloads a file from an mftkit Server.
+func (m *MFT) downloadTCP(server, filePath, destinationPath string) error {
+	var writer *checkpointWriter
+	header := TransferHeader{Op: OpDownload, Path: filePath}
+	if checkpoint, err := loadCheckpoint(destinationPath); err == nil {
+		writer, err = resumeTransfer(destinationPath, checkpoint.Size, checkpoint.Checksum)
+		if err != nil {
+			return err
+		}
+		header.Offset = writer.Offset()
+		header.Checksum = writer.checkpoint.Checksum
+	}
+
+	conn, err := m.dial(server)
 	if err != nil {
+		if writer != nil {
+			writer.abort()
+		}
 		return err
 	}
 	defer conn.Close()
 
-	file, err := os.Create(destinationPath)
+	status, err := m.requestDownload(conn, header)
 	if err != nil {
+		if writer != nil {
+			writer.abort()
+		}
 		return err
 	}
-	defer file.Close()
 
-	if _, err := io.Copy(file, conn); err != nil {
+	if writer == nil {
+		writer, err = resumeTransfer(destinationPath, status.Size, status.Checksum)
+		if err != nil {
+			return err
+		}
+	}
+	if status.Offset != writer.Offset() || status.Checksum != writer.checkpoint.Checksum {
+		if status.Offset != 0 {
+			writer.abort()
+			return errors.New("invalid resume offset")
+		}
+		if err := writer.reset(status.Size, status.Checksum); err != nil {
+			writer.discard()
+			return err
+		}
+	}
+
+	if _, err := io.CopyN(writer, conn, status.Size-status.Offset); err != nil {
+		writer.abort()
 		return err
 	}
 
-	return nil
+	_, err = writer.commit(m)
+	return err
+}
+
+// requestDownload sends a download request and reads the server's response.
+func (m *MFT) requestDownload(conn net.Conn, header TransferHeader) (TransferStatus, error) {
+	var status TransferStatus
+	if err := writeFrame(conn, header); err != nil {
+		return status, err
+	}
+	if err := readFrame(conn, maxResponseFrameSize, &status); err != nil {
+		return status, err
+	}
+	if err := status.Err(); err != nil {
+		return status, err
+	}
+	if status.Offset < 0 || status.Offset > status.Size {
+		return status, errors.New("invalid resume offset")
+	}
+	return status, nil
 }
 
 // LogTransfer logs the file transfer action.
@@ -305,6 +329,8 @@ type FileEvent struct {
 }
 
 // MonitorDirectory monitors a directory for changes and calls the callback function on each event.
+// It does not watch subdirectories and cannot be stopped; see WatchTree, or PollTree for network
+// mounts where fsnotify misses changes made by other hosts.
 func (m *MFT) MonitorDirectory(directoryPath string, callback func(event FileEvent)) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -429,7 +455,8 @@ func (m *MFT) RegisterNotificationHandler(handler func(event TransferEvent)) {
 	handler(TransferEvent{Action: "Upload", FileName: "example.txt", Status: "Success"})
 }
 
-// Schedule represents a file transfer schedule.
+// Schedule represents a file transfer schedule. Destination is a URL such as
+// "sftp://partner@host/inbound/report.csv"; a value without a scheme is a local path.
 type Schedule struct {
 	Time        time.Time
 	FilePath    string
@@ -438,9 +465,13 @@ type Schedule struct {
 
 // ScheduleFileTransfer schedules a file transfer.
 func (m *MFT) ScheduleFileTransfer(schedule Schedule) error {
+	if _, _, err := m.resolveURL(schedule.Destination); err != nil {
+		return err
+	}
+
 	duration := time.Until(schedule.Time)
 	time.AfterFunc(duration, func() {
-		err := m.UploadFile("server_address", schedule.FilePath, schedule.Destination)
+		err := m.PutFile(schedule.FilePath, schedule.Destination)
 		if err != nil {
 			fmt.Println("Scheduled transfer failed:", err)
 		} else {
@@ -674,7 +705,13 @@ func (m *MFT) GetFileOwner(filePath string) (string, error) {
 }
 
 // MultiThreadedUpload uploads a file to a remote server using multiple threads.
+// The parts are stored below the destination directory URL.
 func (m *MFT) MultiThreadedUpload(filePath, destination string, numThreads int) error {
+	transport, base, err := m.resolveURL(destination)
+	if err != nil {
+		return err
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
@@ -715,7 +752,7 @@ func (m *MFT) MultiThreadedUpload(filePath, destination string, numThreads int)
 				fmt.Printf("Error occurred:%v", err)
 				return
 			}
-			err = m.UploadFile(destination, partFilePath, destination+"/"+filepath.Base(partFilePath))
+			err = transport.Put(partFilePath, joinURL(base, filepath.Base(partFilePath)))
 			if err != nil {
 				fmt.Printf("Error occurred:%v", err)
 				return
@@ -919,8 +956,13 @@ func (m *MFT) RevertToFileVersion(filePath, versionID string) error {
 	return nil
 }
 
-// SyncDirectories synchronizes the content of two directories.
+// SyncDirectories synchronizes the content of two directories. targetDir may be a
+// URL, in which case every file is uploaded through the transport for its scheme.
 func (m *MFT) SyncDirectories(sourceDir, targetDir string) error {
+	if isTransferURL(targetDir) {
+		return m.syncToURL(sourceDir, targetDir)
+	}
+
 	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -940,6 +982,28 @@ func (m *MFT) SyncDirectories(sourceDir, targetDir string) error {
 	return err
 }
 
+// syncToURL uploads every file below sourceDir to the same relative path below targetURL.
+func (m *MFT) syncToURL(sourceDir, targetURL string) error {
+	transport, base, err := m.resolveURL(targetURL)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+		return transport.Put(path, joinURL(base, relPath))
+	})
+}
+
 // copyFile copies a file from src to dst.
 func (m *MFT) CopyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
@@ -961,49 +1025,35 @@ func (m *MFT) CopyFile(src, dst string) error {
 	return nil
 }
 
-// VerifyDataIntegrity verifies the integrity of a file using the specified hash type.
+// VerifyDataIntegrity verifies the integrity of a file using the specified hash
+// type, which may be any algorithm in the hash registry.
+// For whole directories, see GenerateChecksumManifest and VerifyChecksumManifest.
 func (m *MFT) VerifyDataIntegrity(filePath, hashType, expectedHash string) (bool, error) {
-	var hasher hash.Hash
-	switch hashType {
-	case "md5":
-		hasher = md5.New()
-	case "sha1":
-		hasher = sha1.New()
-	case "sha256":
-		hasher = sha256.New()
-	default:
-		return false, errors.New("unsupported hash type")
-	}
-
-	file, err := os.Open(filePath)
+	calculatedHash, err := hashFile(filePath, hashType)
 	if err != nil {
 		return false, err
 	}
-	defer file.Close()
-
-	if _, err := io.Copy(hasher, file); err != nil {
-		return false, err
-	}
-
-	calculatedHash := hex.EncodeToString(hasher.Sum(nil))
 	return calculatedHash == expectedHash, nil
 }
 
-var customProtocolHandlers = make(map[string]func(request Request) (Response, error))
-
-// AddCustomProtocolHandler adds a handler for a custom protocol.
+// AddCustomProtocolHandler adds a handler for a custom protocol. The handler is
+// registered as the transport for the protocol's URL scheme, so URLs such as
+// "protocol://host/path" can be used with every transfer function.
 func (m *MFT) AddCustomProtocolHandler(protocol string, handler func(request Request) (Response, error)) error {
 	if protocol == "" {
 		return errors.New("protocol name cannot be empty")
 	}
-	customProtocolHandlers[protocol] = handler
-	return nil
+	return m.RegisterTransport(protocol, &protocolHandlerTransport{protocol: protocol, handler: handler})
 }
 
-// Request represents a custom protocol request.
+// Request represents a custom protocol request. Requests issued through the
+// transport layer carry one of OpUpload, OpDownload, OpList, OpStat and OpDelete
+// in Operation and the URL path in Path.
 type Request struct {
-	Protocol string
-	Data     []byte
+	Protocol  string
+	Operation string
+	Path      string
+	Data      []byte
 }
 
 // Response represents a custom protocol response.
@@ -1014,11 +1064,15 @@ type Response struct {
 
 // HandleCustomProtocolRequest handles a custom protocol request.
 func (m *MFT) HandleCustomProtocolRequest(request Request) (Response, error) {
-	handler, exists := customProtocolHandlers[request.Protocol]
-	if !exists {
+	transportsMu.RLock()
+	transport, exists := transports[strings.ToLower(request.Protocol)]
+	transportsMu.RUnlock()
+
+	handlerTransport, ok := transport.(*protocolHandlerTransport)
+	if !exists || !ok {
 		return Response{}, errors.New("no handler for protocol")
 	}
-	return handler(request)
+	return handlerTransport.handler(request)
 }
 
 // ArchiveFiles creates a zip archive from a list of files.
@@ -1029,17 +1083,17 @@ func (m *MFT) ArchiveFiles(filePaths []string, archivePath string) error {
 	}
 	defer archiveFile.Close()
 
-	zipWriter := zip.NewWriter(archiveFile)
-	defer zipWriter.Close()
-
+	builder := m.NewZipBuilder(archiveFile, ArchiveBuilderOptions{})
 	for _, filePath := range filePaths {
-		err := m.AddFileToZip(zipWriter, filePath)
-		if err != nil {
+		if err := builder.AddFile(filePath, ""); err != nil {
 			return err
 		}
 	}
+	if err := builder.Close(); err != nil {
+		return err
+	}
 
-	return nil
+	return archiveFile.Close()
 }
 
 // AddFileToZip adds a file to a zip archive.
@@ -1070,28 +1124,73 @@ func (m *MFT) AddFileToZip(zipWriter *zip.Writer, filePath string) error {
 	return err
 }
 
-// UnarchiveFile extracts a zip archive to the specified destination.
+// UnarchiveFile extracts a zip archive to the specified destination with the
+// default limits of ExtractOptions.
 func (m *MFT) UnarchiveFile(archivePath, destinationDir string) error {
+	return m.UnarchiveFileWithOptions(archivePath, destinationDir, ExtractOptions{})
+}
+
+// UnarchiveFileWithOptions extracts a zip archive like ExtractArchive extracts a
+// tar: unsafe paths and symlinks and archives over the limits of options stop
+// extraction with an *ArchiveError. WinZip AES entries are decrypted with
+// options.Password.
+func (m *MFT) UnarchiveFileWithOptions(archivePath, destinationDir string, options ExtractOptions) error {
 	archiveFile, err := zip.OpenReader(archivePath)
 	if err != nil {
 		return err
 	}
 	defer archiveFile.Close()
 
+	extractor, err := newExtractor(destinationDir, options)
+	if err != nil {
+		return err
+	}
+
 	for _, file := range archiveFile.File {
-		filePath := filepath.Join(destinationDir, file.Name)
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(filePath, os.ModePerm)
-			continue
+		filePath, err := extractor.entry(file.Name)
+		if err != nil {
+			return err
 		}
+		if err := m.extractZipEntry(extractor, file, filePath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MFT) extractZipEntry(extractor *extractor, file *zip.File, filePath string) error {
+	mode := file.Mode()
+	if mode.IsDir() {
+		return extractor.mkdir(filePath)
+	}
+
+	reader, err := openZipEntry(file, extractor.options.Password)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
 
-		err := m.ExtractFileFromZip(file, filePath)
+	if mode&os.ModeSymlink != 0 {
+		linkname, err := io.ReadAll(io.LimitReader(reader, 4096))
 		if err != nil {
 			return err
 		}
+		return extractor.symlink(file.Name, filePath, string(linkname))
 	}
 
-	return nil
+	compressed := int64(file.CompressedSize64)
+	ratio := func(written int64) (int64, int64) {
+		return written, compressed
+	}
+	if err := extractor.writeFile(file.Name, filePath, reader, ratio); err != nil {
+		if file.Flags&zipFlagEncrypted != 0 {
+			// Do not leave unauthenticated plaintext behind.
+			os.Remove(filePath)
+		}
+		return err
+	}
+	return extractor.restore(filePath, mode, time.Time{}, file.Modified)
 }
 
 // ExtractFileFromZip extracts a file from a zip archive.
@@ -244,7 +244,8 @@ func (m *MFT) IsEmptyDirectory(directoryPath string) (bool, error) {
 	return false, err
 }
 
-// WatchDirectory watches a directory for changes.
+// WatchDirectory watches a directory for changes. It does not watch
+// subdirectories and cannot be stopped; see WatchTree.
 func (m *MFT) WatchDirectory(directoryPath string, callback func(event fsnotify.Event)) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -0,0 +1,222 @@
+package mft
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultWatchDebounce is the quiet period WatchTree waits for after the last
+// write to a file when WatchOptions.Debounce is zero.
+const DefaultWatchDebounce = 250 * time.Millisecond
+
+// WatchOptions controls WatchTree.
+type WatchOptions struct {
+	// Include lists glob patterns (path.Match syntax) that the path relative to
+	// the root or its base name must match. An empty list includes everything.
+	Include []string
+	// Exclude lists glob patterns for paths to ignore. Excluded directories are
+	// not watched at all.
+	Exclude []string
+	// Debounce is how long a file must be quiet before its Create, Write and
+	// Chmod events are delivered as one event. Negative delivers every event.
+	Debounce time.Duration
+	// OnError receives errors that do not stop the watcher, such as a failure to
+	// watch a new subdirectory or an event queue overflow.
+	OnError func(err error)
+}
+
+// WatchTree watches root and every directory below it, including directories
+// created later, and calls callback with the events of paths that pass the
+// filters of options. Bursts of writes to a file are coalesced into a single
+// event whose Op combines the operations seen. Callbacks run one at a time.
+// WatchTree returns nil when ctx is cancelled.
+func (m *MFT) WatchTree(ctx context.Context, root string, options WatchOptions, callback func(event FileEvent)) error {
+	if options.Debounce == 0 {
+		options.Debounce = DefaultWatchDebounce
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	tree := &treeWatcher{
+		root:     root,
+		options:  options,
+		watcher:  watcher,
+		callback: callback,
+		pending:  make(map[string]*pendingEvent),
+	}
+	if err := tree.addTree(root, false); err != nil {
+		return err
+	}
+	return tree.run(ctx)
+}
+
+type pendingEvent struct {
+	op       fsnotify.Op
+	deadline time.Time
+}
+
+type treeWatcher struct {
+	root     string
+	options  WatchOptions
+	watcher  *fsnotify.Watcher
+	callback func(event FileEvent)
+	pending  map[string]*pendingEvent
+	timer    *time.Timer
+}
+
+func (t *treeWatcher) run(ctx context.Context) error {
+	t.timer = time.NewTimer(time.Hour)
+	t.timer.Stop()
+	defer t.timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-t.watcher.Events:
+			if !ok {
+				return nil
+			}
+			t.handle(event)
+		case err, ok := <-t.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			t.report(err)
+		case <-t.timer.C:
+			t.flush(time.Now())
+		}
+	}
+}
+
+func (t *treeWatcher) handle(event fsnotify.Event) {
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Lstat(event.Name); err == nil && info.IsDir() && t.wanted(event.Name, info) {
+			// Files created before the new directory was watched are reported
+			// as created.
+			if err := t.addTree(event.Name, true); err != nil {
+				t.report(err)
+			}
+		}
+	}
+	if t.included(event.Name) {
+		t.queue(event)
+	}
+}
+
+// queue delivers an event, or holds it until the file has been quiet for the
+// debounce period.
+func (t *treeWatcher) queue(event fsnotify.Event) {
+	if t.options.Debounce < 0 || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		delete(t.pending, event.Name)
+		t.callback(FileEvent{Op: event.Op, Name: event.Name})
+		return
+	}
+	pending, exists := t.pending[event.Name]
+	if !exists {
+		pending = &pendingEvent{}
+		t.pending[event.Name] = pending
+	}
+	pending.op |= event.Op
+	pending.deadline = time.Now().Add(t.options.Debounce)
+	t.schedule()
+}
+
+// flush delivers the pending events whose quiet period ended by now.
+func (t *treeWatcher) flush(now time.Time) {
+	for name, pending := range t.pending {
+		if !pending.deadline.After(now) {
+			delete(t.pending, name)
+			t.callback(FileEvent{Op: pending.op, Name: name})
+		}
+	}
+	t.schedule()
+}
+
+// schedule sets the timer to the earliest pending deadline.
+func (t *treeWatcher) schedule() {
+	var next time.Time
+	for _, pending := range t.pending {
+		if next.IsZero() || pending.deadline.Before(next) {
+			next = pending.deadline
+		}
+	}
+	if !t.timer.Stop() {
+		select {
+		case <-t.timer.C:
+		default:
+		}
+	}
+	if !next.IsZero() {
+		t.timer.Reset(time.Until(next))
+	}
+}
+
+// addTree watches dir and the directories below it. With reportFiles, files
+// already present are delivered as Create events.
+func (t *treeWatcher) addTree(dir string, reportFiles bool) error {
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if filePath != dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if filePath != t.root && !t.wanted(filePath, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if err := t.watcher.Add(filePath); err != nil {
+				return err
+			}
+			if filePath != dir && reportFiles && t.included(filePath) {
+				t.queue(fsnotify.Event{Name: filePath, Op: fsnotify.Create})
+			}
+			return nil
+		}
+		if reportFiles {
+			t.queue(fsnotify.Event{Name: filePath, Op: fsnotify.Create})
+		}
+		return nil
+	})
+}
+
+// wanted reports whether a directory should be watched or a file reported.
+// Directories only need to escape the exclude patterns.
+func (t *treeWatcher) wanted(filePath string, info os.FileInfo) bool {
+	if info.IsDir() {
+		return !matchAny(t.options.Exclude, t.relative(filePath))
+	}
+	return t.included(filePath)
+}
+
+func (t *treeWatcher) included(filePath string) bool {
+	name := t.relative(filePath)
+	if matchAny(t.options.Exclude, name) {
+		return false
+	}
+	return len(t.options.Include) == 0 || matchAny(t.options.Include, name)
+}
+
+func (t *treeWatcher) relative(filePath string) string {
+	relPath, err := filepath.Rel(t.root, filePath)
+	if err != nil {
+		return filepath.ToSlash(filePath)
+	}
+	return filepath.ToSlash(relPath)
+}
+
+func (t *treeWatcher) report(err error) {
+	if t.options.OnError != nil {
+		t.options.OnError(err)
+	}
+}
@@ -0,0 +1,370 @@
+package mft
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"io"
+	"os"
+)
+
+// WinZip AES encryption (AE-2) as read by WinZip, 7-Zip and most desktop tools.
+// An encrypted entry uses compression method 99 and an extra field that records
+// the key strength and the real compression method. Its data is
+//
+//	salt (16) | password verifier (2) | AES-256-CTR ciphertext | HMAC-SHA1 (10)
+//
+// with keys derived from the password by PBKDF2-HMAC-SHA1 with 1000 iterations.
+const (
+	zipMethodAES       = 99
+	zipAESExtraID      = 0x9901
+	zipAESVersion      = 2
+	zipAESStrength256  = 3
+	zipAESSaltSize     = 16
+	zipAESKeySize      = 32
+	zipAESVerifierSize = 2
+	zipAESAuthSize     = 10
+	zipAESIterations   = 1000
+	zipAESZipVersion   = 51
+	zipFlagEncrypted   = 0x1
+	zipFlagDescriptor  = 0x8
+)
+
+var (
+	// ErrZipPasswordRequired is returned for encrypted zip entries when no password is given.
+	ErrZipPasswordRequired = errors.New("entry is encrypted and needs a password")
+	// ErrZipWrongPassword is returned when the password does not match an encrypted zip entry.
+	ErrZipWrongPassword = errors.New("wrong password for encrypted entry")
+	// ErrZipCorrupt is returned when an encrypted zip entry fails authentication.
+	ErrZipCorrupt = errors.New("encrypted entry is corrupt")
+	// ErrZipUnsupportedEncryption is returned for encryption other than WinZip AES.
+	ErrZipUnsupportedEncryption = errors.New("entry uses an unsupported zip encryption")
+)
+
+// ArchiveFilesWithPassword creates a zip archive like ArchiveFiles with every
+// file encrypted with WinZip AES-256.
+func (m *MFT) ArchiveFilesWithPassword(filePaths []string, archivePath, password string) error {
+	if password == "" {
+		return errors.New("password cannot be empty")
+	}
+	archiveFile, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archiveFile.Close()
+
+	builder := m.NewZipBuilder(archiveFile, ArchiveBuilderOptions{Password: password})
+	for _, filePath := range filePaths {
+		if err := builder.AddFile(filePath, ""); err != nil {
+			return err
+		}
+	}
+	if err := builder.Close(); err != nil {
+		return err
+	}
+	return archiveFile.Close()
+}
+
+// UnarchiveFileWithPassword extracts a zip archive like UnarchiveFile, decrypting
+// WinZip AES entries with password.
+func (m *MFT) UnarchiveFileWithPassword(archivePath, destinationDir, password string) error {
+	return m.UnarchiveFileWithOptions(archivePath, destinationDir, ExtractOptions{Password: password})
+}
+
+type zipAESKeys struct {
+	encryption []byte
+	auth       []byte
+	verifier   []byte
+}
+
+func deriveZipAESKeys(password string, salt []byte) zipAESKeys {
+	key := pbkdf2.Key([]byte(password), salt, zipAESIterations, 2*zipAESKeySize+zipAESVerifierSize, sha1.New)
+	return zipAESKeys{
+		encryption: key[:zipAESKeySize],
+		auth:       key[zipAESKeySize : 2*zipAESKeySize],
+		verifier:   key[2*zipAESKeySize:],
+	}
+}
+
+// zipAESStream is AES in counter mode with the little-endian counter, starting
+// at 1, that WinZip uses instead of the big-endian counter of cipher.NewCTR.
+type zipAESStream struct {
+	block     cipher.Block
+	counter   uint64
+	keystream [aes.BlockSize]byte
+	used      int
+}
+
+func newZipAESStream(key []byte) (*zipAESStream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &zipAESStream{block: block, used: aes.BlockSize}, nil
+}
+
+func (s *zipAESStream) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.used == aes.BlockSize {
+			s.counter++
+			var counter [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(counter[:], s.counter)
+			s.block.Encrypt(s.keystream[:], counter[:])
+			s.used = 0
+		}
+		dst[i] = src[i] ^ s.keystream[s.used]
+		s.used++
+	}
+}
+
+// zipAESExtra returns the extra field of an AES-256 entry whose data is
+// compressed with method.
+func zipAESExtra(method uint16) []byte {
+	extra := make([]byte, 0, 11)
+	extra = binary.LittleEndian.AppendUint16(extra, zipAESExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, 7)
+	extra = binary.LittleEndian.AppendUint16(extra, zipAESVersion)
+	extra = append(extra, 'A', 'E', zipAESStrength256)
+	return binary.LittleEndian.AppendUint16(extra, method)
+}
+
+// parseZipAESExtra returns the key strength and real compression method from
+// the extra field of an AES entry.
+func parseZipAESExtra(extra []byte) (byte, uint16, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if id == zipAESExtraID && size >= 7 && extra[6] == 'A' && extra[7] == 'E' {
+			return extra[8], binary.LittleEndian.Uint16(extra[9:]), true
+		}
+		extra = extra[4+size:]
+	}
+	return 0, 0, false
+}
+
+// zipAESWriter encrypts and authenticates deflated entry data written through it.
+type zipAESWriter struct {
+	header     *zip.FileHeader
+	raw        *countingWriter
+	mac        hash.Hash
+	stream     *zipAESStream
+	compressor *flate.Writer
+	size       int64
+}
+
+// createEncryptedZipEntry starts an AES-256 entry for header in zipWriter.
+// The header's method, flags and sizes are set by the returned writer.
+func createEncryptedZipEntry(zipWriter *zip.Writer, header *zip.FileHeader, password string) (*zipAESWriter, error) {
+	header.Extra = append(header.Extra, zipAESExtra(zip.Deflate)...)
+	header.Method = zipMethodAES
+	header.Flags |= zipFlagEncrypted | zipFlagDescriptor
+	header.CreatorVersion = header.CreatorVersion&0xff00 | zipAESZipVersion
+	header.ReaderVersion = zipAESZipVersion
+	header.CRC32 = 0
+	header.CompressedSize64 = 0
+	header.UncompressedSize64 = 0
+
+	rawWriter, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, zipAESSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	keys := deriveZipAESKeys(password, salt)
+	stream, err := newZipAESStream(keys.encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &zipAESWriter{header: header, raw: &countingWriter{w: rawWriter}, mac: hmac.New(sha1.New, keys.auth), stream: stream}
+	if _, err := w.raw.Write(salt); err != nil {
+		return nil, err
+	}
+	if _, err := w.raw.Write(keys.verifier); err != nil {
+		return nil, err
+	}
+	w.compressor, err = flate.NewWriter(zipAESCipherWriter{w}, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *zipAESWriter) Write(p []byte) (int, error) {
+	n, err := w.compressor.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close flushes the entry and records its sizes for the data descriptor and
+// central directory. It must be called before the next entry is created.
+func (w *zipAESWriter) Close() error {
+	if err := w.compressor.Close(); err != nil {
+		return err
+	}
+	if _, err := w.raw.Write(w.mac.Sum(nil)[:zipAESAuthSize]); err != nil {
+		return err
+	}
+	w.header.CompressedSize64 = uint64(w.raw.count)
+	w.header.UncompressedSize64 = uint64(w.size)
+	w.header.CompressedSize = uint32(min(w.header.CompressedSize64, 0xffffffff))
+	w.header.UncompressedSize = uint32(min(w.header.UncompressedSize64, 0xffffffff))
+	return nil
+}
+
+// zipAESCipherWriter encrypts compressed data and adds it to the MAC.
+type zipAESCipherWriter struct {
+	w *zipAESWriter
+}
+
+func (c zipAESCipherWriter) Write(p []byte) (int, error) {
+	encrypted := make([]byte, len(p))
+	c.w.stream.XORKeyStream(encrypted, p)
+	c.w.mac.Write(encrypted)
+	return c.w.raw.Write(encrypted)
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// openZipEntry opens a zip entry, decrypting WinZip AES entries with password.
+func openZipEntry(file *zip.File, password string) (io.ReadCloser, error) {
+	if file.Flags&zipFlagEncrypted == 0 {
+		return file.Open()
+	}
+	if file.Method != zipMethodAES {
+		return nil, &ArchiveError{Entry: file.Name, Err: ErrZipUnsupportedEncryption}
+	}
+	strength, method, ok := parseZipAESExtra(file.Extra)
+	if !ok || strength < 1 || strength > 3 {
+		return nil, &ArchiveError{Entry: file.Name, Err: ErrZipCorrupt}
+	}
+	if password == "" {
+		return nil, &ArchiveError{Entry: file.Name, Err: ErrZipPasswordRequired}
+	}
+
+	// Strength 1, 2 and 3 select AES-128, AES-192 and AES-256.
+	keySize := 8 + 8*int(strength)
+	saltSize := keySize / 2
+	overhead := int64(saltSize + zipAESVerifierSize + zipAESAuthSize)
+	if int64(file.CompressedSize64) < overhead {
+		return nil, &ArchiveError{Entry: file.Name, Err: ErrZipCorrupt}
+	}
+
+	raw, err := file.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, saltSize+zipAESVerifierSize)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, &ArchiveError{Entry: file.Name, Err: ErrZipCorrupt}
+	}
+
+	derived := pbkdf2.Key([]byte(password), header[:saltSize], zipAESIterations, 2*keySize+zipAESVerifierSize, sha1.New)
+	if subtle.ConstantTimeCompare(derived[2*keySize:], header[saltSize:]) != 1 {
+		return nil, &ArchiveError{Entry: file.Name, Err: ErrZipWrongPassword}
+	}
+	stream, err := newZipAESStream(derived[:keySize])
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := &zipAESReader{
+		name:   file.Name,
+		raw:    raw,
+		data:   io.LimitReader(raw, int64(file.CompressedSize64)-overhead),
+		mac:    hmac.New(sha1.New, derived[keySize:2*keySize]),
+		stream: stream,
+	}
+	switch method {
+	case zip.Store:
+		return io.NopCloser(decrypted), nil
+	case zip.Deflate:
+		return &zipAESInflater{inflater: flate.NewReader(decrypted), source: decrypted}, nil
+	}
+	return nil, &ArchiveError{Entry: file.Name, Err: zip.ErrAlgorithm}
+}
+
+// zipAESReader decrypts entry data and checks the authentication code when the
+// data ends, returning ErrZipCorrupt instead of io.EOF if it does not match.
+type zipAESReader struct {
+	name     string
+	raw      io.Reader
+	data     io.Reader
+	mac      hash.Hash
+	stream   *zipAESStream
+	verified bool
+}
+
+func (r *zipAESReader) Read(p []byte) (int, error) {
+	if r.verified {
+		return 0, io.EOF
+	}
+	n, err := r.data.Read(p)
+	if n > 0 {
+		r.mac.Write(p[:n])
+		r.stream.XORKeyStream(p[:n], p[:n])
+	}
+	if err == io.EOF {
+		if verifyErr := r.verify(); verifyErr != nil {
+			return n, verifyErr
+		}
+	}
+	return n, err
+}
+
+func (r *zipAESReader) verify() error {
+	code := make([]byte, zipAESAuthSize)
+	if _, err := io.ReadFull(r.raw, code); err != nil || !hmac.Equal(code, r.mac.Sum(nil)[:zipAESAuthSize]) {
+		return &ArchiveError{Entry: r.name, Err: ErrZipCorrupt}
+	}
+	r.verified = true
+	return nil
+}
+
+// zipAESInflater decompresses an AES entry. At the end of the compressed stream
+// it reads any remaining data so the authentication code is always checked.
+type zipAESInflater struct {
+	inflater io.ReadCloser
+	source   *zipAESReader
+}
+
+func (z *zipAESInflater) Read(p []byte) (int, error) {
+	n, err := z.inflater.Read(p)
+	if err == io.EOF {
+		if _, drainErr := io.Copy(io.Discard, z.source); drainErr != nil {
+			return n, drainErr
+		}
+	} else if err != nil {
+		// A damaged deflate stream is reported as corruption of the entry.
+		return n, &ArchiveError{Entry: z.source.name, Err: ErrZipCorrupt}
+	}
+	return n, err
+}
+
+func (z *zipAESInflater) Close() error {
+	return z.inflater.Close()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/madhu72/mftkit/mft"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePGPKeys generates a key pair and writes its passphrase-protected private
+// key as an armored keyring and its public key as a binary keyring.
+func writePGPKeys(t *testing.T, dir, name, passphrase string) (privatePath, publicPath string) {
+	t.Helper()
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", config)
+	if err != nil {
+		t.Fatalf("Error generating PGP key: %v", err)
+	}
+
+	publicPath = filepath.Join(dir, name+".gpg")
+	publicFile, _ := os.Create(publicPath)
+	if err := entity.Serialize(publicFile); err != nil {
+		t.Fatalf("Error writing public key: %v", err)
+	}
+	publicFile.Close()
+
+	if err := entity.EncryptPrivateKeys([]byte(passphrase), nil); err != nil {
+		t.Fatalf("Error protecting private key: %v", err)
+	}
+	privatePath = filepath.Join(dir, name+".asc")
+	privateFile, _ := os.Create(privatePath)
+	armored, _ := armor.Encode(privateFile, openpgp.PrivateKeyType, nil)
+	if err := entity.SerializePrivateWithoutSigning(armored, nil); err != nil {
+		t.Fatalf("Error writing private key: %v", err)
+	}
+	armored.Close()
+	privateFile.Close()
+	return privatePath, publicPath
+}
+
+func loadKeyRing(t *testing.T, utils *mft.MFT, paths ...string) openpgp.EntityList {
+	t.Helper()
+	var keyring openpgp.EntityList
+	for _, path := range paths {
+		keys, err := utils.LoadPGPKeyRing(path)
+		if err != nil {
+			t.Fatalf("Error loading keyring %s: %v", path, err)
+		}
+		keyring = append(keyring, keys...)
+	}
+	return keyring
+}
+
+func TestPGPEncryptDecrypt(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	bankPrivate, bankPublic := writePGPKeys(t, dir, "bank", "bank-pass")
+	payrollPrivate, payrollPublic := writePGPKeys(t, dir, "payroll", "payroll-pass")
+	senderPrivate, senderPublic := writePGPKeys(t, dir, "sender", "sender-pass")
+
+	signer := loadKeyRing(t, utils, senderPrivate)
+	if err := utils.UnlockPGPKeys(signer, "wrong"); !errors.Is(err, mft.ErrPGPWrongPassphrase) {
+		t.Errorf("Expected ErrPGPWrongPassphrase, got: %v", err)
+	}
+	if err := utils.UnlockPGPKeys(signer, "sender-pass"); err != nil {
+		t.Fatalf("Error unlocking signing key: %v", err)
+	}
+
+	source := filepath.Join(dir, "payroll.csv")
+	writeTestFile(t, source, 300<<10)
+	recipients := loadKeyRing(t, utils, bankPublic, payrollPublic)
+
+	for _, armored := range []bool{false, true} {
+		encrypted := filepath.Join(dir, "payroll.csv.pgp")
+		options := mft.PGPOptions{Armor: armored, Signer: signer[0]}
+		if err := utils.PGPEncryptFile(source, encrypted, recipients, options); err != nil {
+			t.Fatalf("Error encrypting (armor %v): %v", armored, err)
+		}
+
+		for _, recipient := range []struct{ private, passphrase string }{
+			{bankPrivate, "bank-pass"},
+			{payrollPrivate, "payroll-pass"},
+		} {
+			keyring := loadKeyRing(t, utils, recipient.private, senderPublic)
+			decrypted := filepath.Join(dir, "decrypted.csv")
+			signedBy, err := utils.PGPDecryptFile(encrypted, decrypted, keyring, recipient.passphrase)
+			if err != nil {
+				t.Fatalf("Error decrypting (armor %v): %v", armored, err)
+			}
+			if signedBy == nil || signedBy.PrimaryKey.KeyId != signer[0].PrimaryKey.KeyId {
+				t.Errorf("Expected the message to be signed by the sender")
+			}
+			assertSameChecksum(t, utils, source, decrypted)
+		}
+	}
+
+	encrypted := filepath.Join(dir, "payroll.csv.pgp")
+	decrypted := filepath.Join(dir, "wrong.csv")
+	keyring := loadKeyRing(t, utils, bankPrivate, senderPublic)
+	if _, err := utils.PGPDecryptFile(encrypted, decrypted, keyring, "not-the-passphrase"); !errors.Is(err, mft.ErrPGPWrongPassphrase) {
+		t.Errorf("Expected ErrPGPWrongPassphrase, got: %v", err)
+	}
+
+	keyring = loadKeyRing(t, utils, bankPrivate)
+	if _, err := utils.PGPDecryptFile(encrypted, decrypted, keyring, "bank-pass"); !errors.Is(err, mft.ErrPGPUnknownSigner) {
+		t.Errorf("Expected ErrPGPUnknownSigner without the sender's key, got: %v", err)
+	}
+	if utils.CheckFileExists(decrypted) {
+		t.Errorf("Expected no output when the signature cannot be verified")
+	}
+}
+
+func TestPGPSignatures(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	signerPrivate, signerPublic := writePGPKeys(t, dir, "signer", "signer-pass")
+	_, otherPublic := writePGPKeys(t, dir, "other", "other-pass")
+
+	signer := loadKeyRing(t, utils, signerPrivate)
+	if err := utils.UnlockPGPKeys(signer, "signer-pass"); err != nil {
+		t.Fatalf("Error unlocking signing key: %v", err)
+	}
+	keyring := loadKeyRing(t, utils, signerPublic)
+
+	source := filepath.Join(dir, "statement.txt")
+	writeTestFile(t, source, 100<<10)
+
+	for _, armored := range []bool{false, true} {
+		signature := filepath.Join(dir, "statement.txt.sig")
+		if err := utils.PGPDetachSignFile(source, signature, signer[0], armored); err != nil {
+			t.Fatalf("Error signing (armor %v): %v", armored, err)
+		}
+		if _, err := utils.PGPVerifyDetachedSignature(source, signature, keyring); err != nil {
+			t.Errorf("Error verifying detached signature (armor %v): %v", armored, err)
+		}
+		if _, err := utils.PGPVerifyDetachedSignature(source, signature, loadKeyRing(t, utils, otherPublic)); !errors.Is(err, mft.ErrPGPUnknownSigner) {
+			t.Errorf("Expected ErrPGPUnknownSigner, got: %v", err)
+		}
+
+		signed := filepath.Join(dir, "statement.txt.gpg")
+		if err := utils.PGPSignFile(source, signed, signer[0], armored); err != nil {
+			t.Fatalf("Error signing inline (armor %v): %v", armored, err)
+		}
+		extracted := filepath.Join(dir, "extracted.txt")
+		if _, err := utils.PGPVerifyFile(signed, extracted, keyring); err != nil {
+			t.Fatalf("Error verifying inline signature (armor %v): %v", armored, err)
+		}
+		assertSameChecksum(t, utils, source, extracted)
+	}
+
+	tampered := filepath.Join(dir, "tampered.txt")
+	data, _ := os.ReadFile(source)
+	data[0] ^= 0x01
+	os.WriteFile(tampered, data, 0644)
+	if _, err := utils.PGPVerifyDetachedSignature(tampered, filepath.Join(dir, "statement.txt.sig"), keyring); !errors.Is(err, mft.ErrPGPSignatureInvalid) {
+		t.Errorf("Expected ErrPGPSignatureInvalid, got: %v", err)
+	}
+}
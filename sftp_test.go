@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/madhu72/mftkit/mft"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Error creating signer: %v", err)
+	}
+	return signer
+}
+
+func startSFTPServer(t *testing.T, userKey ssh.PublicKey) (*mft.SFTPServer, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+
+	server := mft.NewSFTPServer(t.TempDir(), newSigner(t))
+	server.Passwords["partner"] = "s3cret"
+	server.AuthorizedKeys["robot"] = []ssh.PublicKey{userKey}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return server, listener.Addr().String()
+}
+
+func TestSFTPPasswordTransfer(t *testing.T) {
+	utils := mft.NewMFT()
+	server, addr := startSFTPServer(t, newSigner(t).PublicKey())
+
+	client, err := utils.ConnectSFTP(addr, mft.SFTPConfig{
+		User:                  "partner",
+		Password:              "s3cret",
+		InsecureIgnoreHostKey: true,
+	})
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer client.Close()
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "invoice.csv")
+	writeTestFile(t, source, 100<<10)
+
+	if err := client.UploadFile(source, "/inbound/invoice.csv"); err != nil {
+		t.Fatalf("Error uploading: %v", err)
+	}
+	assertSameChecksum(t, utils, source, filepath.Join(server.Root, "inbound", "invoice.csv"))
+
+	files, err := client.ListFiles("/inbound")
+	if err != nil {
+		t.Fatalf("Error listing files: %v", err)
+	}
+	if len(files) != 1 || files[0] != "invoice.csv" {
+		t.Errorf("Expected [invoice.csv], got: %v", files)
+	}
+
+	destination := filepath.Join(dir, "downloaded.csv")
+	if err := client.DownloadFile("/inbound/invoice.csv", destination); err != nil {
+		t.Fatalf("Error downloading: %v", err)
+	}
+	assertSameChecksum(t, utils, source, destination)
+
+	if err := client.DeleteFile("/inbound/invoice.csv"); err != nil {
+		t.Fatalf("Error deleting: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(server.Root, "inbound", "invoice.csv")); !os.IsNotExist(err) {
+		t.Errorf("Expected the remote file to be deleted")
+	}
+}
+
+func TestSFTPPublicKeyAndChroot(t *testing.T) {
+	utils := mft.NewMFT()
+	userKey := newSigner(t)
+	server, addr := startSFTPServer(t, userKey.PublicKey())
+
+	client, err := utils.ConnectSFTP(addr, mft.SFTPConfig{
+		User:               "robot",
+		Signer:             userKey,
+		HostKeyFingerprint: ssh.FingerprintSHA256(server.HostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatalf("Error connecting with a public key: %v", err)
+	}
+	defer client.Close()
+
+	source := filepath.Join(t.TempDir(), "report.txt")
+	writeTestFile(t, source, 512)
+
+	if err := client.UploadFile(source, "../../../report.txt"); err != nil {
+		t.Fatalf("Error uploading: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(server.Root, "report.txt")); err != nil {
+		t.Errorf("Expected the upload to stay inside the root: %v", err)
+	}
+}
+
+func TestSFTPRejectsBadCredentials(t *testing.T) {
+	utils := mft.NewMFT()
+	_, addr := startSFTPServer(t, newSigner(t).PublicKey())
+
+	_, err := utils.ConnectSFTP(addr, mft.SFTPConfig{
+		User:                  "partner",
+		Password:              "wrong",
+		InsecureIgnoreHostKey: true,
+	})
+	if err == nil {
+		t.Errorf("Expected a wrong password to be rejected")
+	}
+
+	_, err = utils.ConnectSFTP(addr, mft.SFTPConfig{
+		User:               "partner",
+		Password:           "s3cret",
+		HostKeyFingerprint: "SHA256:not-the-server",
+	})
+	if err == nil {
+		t.Errorf("Expected an unexpected host key to be rejected")
+	}
+}
+
+func TestSFTPSymlinksStayInsideRoot(t *testing.T) {
+	utils := mft.NewMFT()
+	server, addr := startSFTPServer(t, newSigner(t).PublicKey())
+	outside := t.TempDir()
+	writeTestFile(t, filepath.Join(outside, "secret.txt"), 100)
+	os.MkdirAll(filepath.Join(server.Root, "data"), 0755)
+	inside := filepath.Join(server.Root, "data", "a.txt")
+	writeTestFile(t, inside, 100)
+	os.Symlink(outside, filepath.Join(server.Root, "escape"))
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(server.Root, "secret"))
+	os.Symlink(filepath.Join(outside, "created.txt"), filepath.Join(server.Root, "dangling"))
+	os.Symlink(filepath.Join("data", "a.txt"), filepath.Join(server.Root, "current"))
+
+	client, err := utils.ConnectSFTP(addr, mft.SFTPConfig{
+		User:                  "partner",
+		Password:              "s3cret",
+		InsecureIgnoreHostKey: true,
+	})
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer client.Close()
+
+	dir := t.TempDir()
+	for _, remotePath := range []string{"/secret", "/escape/secret.txt"} {
+		if err := client.DownloadFile(remotePath, filepath.Join(dir, "out.txt")); err == nil {
+			t.Errorf("Expected downloading %s to be refused", remotePath)
+		}
+	}
+	if _, err := client.ListFiles("/escape"); err == nil {
+		t.Errorf("Expected listing a symlink outside the root to be refused")
+	}
+	source := filepath.Join(dir, "upload.txt")
+	writeTestFile(t, source, 10)
+	for _, remotePath := range []string{"/dangling", "/escape/upload.txt"} {
+		if err := client.UploadFile(source, remotePath); err == nil {
+			t.Errorf("Expected uploading to %s to be refused", remotePath)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 1 {
+		t.Errorf("Expected nothing to be written outside the root, got: %v", entries)
+	}
+
+	// Symlinks that stay inside the root still work.
+	if err := client.DownloadFile("/current", filepath.Join(dir, "current.txt")); err != nil {
+		t.Fatalf("Error downloading through a symlink inside the root: %v", err)
+	}
+	assertSameChecksum(t, utils, inside, filepath.Join(dir, "current.txt"))
+	if err := client.DeleteFile("/secret"); err != nil {
+		t.Errorf("Error deleting a symlink: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret.txt")); err != nil {
+		t.Errorf("Expected deleting the symlink to keep its target: %v", err)
+	}
+}
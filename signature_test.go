@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"github.com/madhu72/mftkit/mft"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSignature(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+
+	privatePath := filepath.Join(dir, "signing.pem")
+	publicPath := filepath.Join(dir, "signing.pub")
+	if err := utils.GenerateSigningKey(privatePath, publicPath); err != nil {
+		t.Fatalf("Error generating signing key: %v", err)
+	}
+	privateKey, err := utils.LoadSigningKey(privatePath)
+	if err != nil {
+		t.Fatalf("Error loading signing key: %v", err)
+	}
+	publicKey, err := utils.LoadVerifyKey(publicPath)
+	if err != nil {
+		t.Fatalf("Error loading verify key: %v", err)
+	}
+
+	source := filepath.Join(dir, "invoice.pdf")
+	writeTestFile(t, source, 10<<10)
+	signature := source + ".sig"
+	if err := utils.SignFile(source, signature, privateKey); err != nil {
+		t.Fatalf("Error signing file: %v", err)
+	}
+	if err := utils.VerifyFileSignature(source, signature, publicKey); err != nil {
+		t.Errorf("Error verifying signature: %v", err)
+	}
+
+	writeTestFile(t, source, 10<<10+1)
+	if err := utils.VerifyFileSignature(source, signature, publicKey); !errors.Is(err, mft.ErrSignatureInvalid) {
+		t.Errorf("Expected ErrSignatureInvalid for a modified file, got: %v", err)
+	}
+
+	otherPrivate := filepath.Join(dir, "other.pem")
+	otherPublic := filepath.Join(dir, "other.pub")
+	utils.GenerateSigningKey(otherPrivate, otherPublic)
+	otherKey, _ := utils.LoadVerifyKey(otherPublic)
+	if err := utils.SignFile(source, signature, privateKey); err != nil {
+		t.Fatalf("Error signing file: %v", err)
+	}
+	if err := utils.VerifyFileSignature(source, signature, otherKey); !errors.Is(err, mft.ErrSignatureInvalid) {
+		t.Errorf("Expected ErrSignatureInvalid for another key, got: %v", err)
+	}
+
+	// The signing time is covered by the signature.
+	data, _ := os.ReadFile(signature)
+	os.WriteFile(signature, []byte(strings.Replace(string(data), `"signed": "20`, `"signed": "19`, 1)), 0644)
+	if err := utils.VerifyFileSignature(source, signature, publicKey); !errors.Is(err, mft.ErrSignatureInvalid) {
+		t.Errorf("Expected ErrSignatureInvalid for a changed signing time, got: %v", err)
+	}
+}
+
+func TestSignedDirectoryManifest(t *testing.T) {
+	utils := mft.NewMFT()
+	keyDir := t.TempDir()
+	utils.GenerateSigningKey(filepath.Join(keyDir, "key.pem"), filepath.Join(keyDir, "key.pub"))
+	privateKey, _ := utils.LoadSigningKey(filepath.Join(keyDir, "key.pem"))
+	publicKey, _ := utils.LoadVerifyKey(filepath.Join(keyDir, "key.pub"))
+
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "nested"), os.ModePerm)
+	writeTestFile(t, filepath.Join(dir, "a.txt"), 100)
+	writeTestFile(t, filepath.Join(dir, "nested", "b.txt"), 200)
+	manifestPath := filepath.Join(dir, "MANIFEST.json")
+
+	if err := utils.SignDirectory(dir, manifestPath, privateKey); err != nil {
+		t.Fatalf("Error signing directory: %v", err)
+	}
+	manifest, err := utils.VerifyDirectory(dir, manifestPath, publicKey)
+	if err != nil {
+		t.Fatalf("Error verifying directory: %v", err)
+	}
+	if len(manifest.Files) != 2 || manifest.Files[1].Path != "nested/b.txt" {
+		t.Errorf("Unexpected manifest files: %+v", manifest.Files)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "a.txt"), 101)
+	os.Remove(filepath.Join(dir, "nested", "b.txt"))
+	writeTestFile(t, filepath.Join(dir, "c.txt"), 10)
+	_, err = utils.VerifyDirectory(dir, manifestPath, publicKey)
+	if !errors.Is(err, mft.ErrManifestMismatch) {
+		t.Fatalf("Expected ErrManifestMismatch, got: %v", err)
+	}
+	for _, problem := range []string{"changed a.txt", "missing nested/b.txt", "unexpected c.txt"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q in %v", problem, err)
+		}
+	}
+
+	data, _ := os.ReadFile(manifestPath)
+	os.WriteFile(manifestPath, []byte(strings.Replace(string(data), `"size": 100`, `"size": 101`, 1)), 0644)
+	if _, err := utils.VerifyDirectory(dir, manifestPath, publicKey); !errors.Is(err, mft.ErrSignatureInvalid) {
+		t.Errorf("Expected ErrSignatureInvalid for an edited manifest, got: %v", err)
+	}
+
+	os.WriteFile(manifestPath, []byte(strings.Replace(string(data), `"signed": "20`, `"signed": "19`, 1)), 0644)
+	if _, err := utils.VerifyDirectory(dir, manifestPath, publicKey); !errors.Is(err, mft.ErrSignatureInvalid) {
+		t.Errorf("Expected ErrSignatureInvalid for a changed signing time, got: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/madhu72/mftkit/mft"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitAndMergeFromManifest(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "data.bin")
+	original := writeTestFile(t, filePath, 2500)
+
+	parts, err := utils.SplitFile(filePath, 1000)
+	if err != nil {
+		t.Fatalf("Error splitting file: %v", err)
+	}
+	if len(parts) != 3 || parts[2] != filePath+".part2" {
+		t.Fatalf("Expected 3 parts ending in data.bin.part2, got: %v", parts)
+	}
+	manifestPath := filePath + mft.ManifestSuffix
+	manifest, err := utils.ReadSplitManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("Error reading manifest: %v", err)
+	}
+	if manifest.Name != "data.bin" || manifest.Size != 2500 || len(manifest.Parts) != 3 || manifest.Parts[2].Size != 500 {
+		t.Errorf("Expected a manifest for 2500 bytes in 3 parts, got: %+v", manifest)
+	}
+
+	outputPath := filepath.Join(dir, "merged.bin")
+	if err := utils.MergeFromManifest(manifestPath, outputPath); err != nil {
+		t.Fatalf("Error merging parts: %v", err)
+	}
+	if merged, _ := os.ReadFile(outputPath); !bytes.Equal(merged, original) {
+		t.Errorf("Expected merged file to match the original")
+	}
+
+	// Swapping two parts is reported as a reordering.
+	os.Rename(parts[0], filePath+".tmp")
+	os.Rename(parts[1], parts[0])
+	os.Rename(filePath+".tmp", parts[1])
+	if err := utils.MergeFromManifest(manifestPath, outputPath); !errors.Is(err, mft.ErrPartsReordered) {
+		t.Errorf("Expected ErrPartsReordered, got: %v", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the output to be removed, got: %v", err)
+	}
+	os.Rename(parts[0], filePath+".tmp")
+	os.Rename(parts[1], parts[0])
+	os.Rename(filePath+".tmp", parts[1])
+
+	data, _ := os.ReadFile(parts[1])
+	data[10] ^= 0xff
+	os.WriteFile(parts[1], data, 0644)
+	if err := utils.MergeFromManifest(manifestPath, outputPath); !errors.Is(err, mft.ErrPartCorrupt) {
+		t.Errorf("Expected ErrPartCorrupt, got: %v", err)
+	}
+
+	os.Remove(parts[2])
+	if err := utils.MergeFromManifest(manifestPath, outputPath); !errors.Is(err, mft.ErrPartMissing) {
+		t.Errorf("Expected ErrPartMissing, got: %v", err)
+	}
+}
+
+func TestErasureCodedSplit(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "data.bin")
+	original := writeTestFile(t, filePath, 10001)
+
+	manifest, err := utils.SplitFileErasure(filePath, 4, 2, "")
+	if err != nil {
+		t.Fatalf("Error splitting file: %v", err)
+	}
+	if len(manifest.Parts) != 6 || manifest.PartSize != 2501 {
+		t.Fatalf("Expected 6 shards of 2501 bytes, got: %+v", manifest)
+	}
+	manifestPath := filePath + mft.ManifestSuffix
+	outputPath := filepath.Join(dir, "merged.bin")
+
+	// Any two shards may be lost.
+	os.Remove(filePath + ".part1")
+	data, _ := os.ReadFile(filePath + ".part4")
+	data[0] ^= 0xff
+	os.WriteFile(filePath+".part4", data, 0644)
+	if err := utils.MergeFromManifest(manifestPath, outputPath); err != nil {
+		t.Fatalf("Error merging with two lost shards: %v", err)
+	}
+	if merged, _ := os.ReadFile(outputPath); !bytes.Equal(merged, original) {
+		t.Errorf("Expected merged file to match the original")
+	}
+
+	repaired, err := utils.RepairShards(manifestPath)
+	if err != nil {
+		t.Fatalf("Error repairing shards: %v", err)
+	}
+	if len(repaired) != 2 || repaired[0] != "data.bin.part1" || repaired[1] != "data.bin.part4" {
+		t.Errorf("Expected part1 and part4 to be repaired, got: %v", repaired)
+	}
+	if repaired, err := utils.RepairShards(manifestPath); err != nil || len(repaired) != 0 {
+		t.Errorf("Expected nothing left to repair, got: %v, %v", repaired, err)
+	}
+
+	for _, index := range []string{"0", "2", "5"} {
+		os.Remove(filePath + ".part" + index)
+	}
+	if err := utils.MergeFromManifest(manifestPath, outputPath); !errors.Is(err, mft.ErrTooFewShards) {
+		t.Errorf("Expected ErrTooFewShards, got: %v", err)
+	}
+	if _, err := utils.RepairShards(manifestPath); !errors.Is(err, mft.ErrTooFewShards) {
+		t.Errorf("Expected ErrTooFewShards from repair, got: %v", err)
+	}
+}
+
+func TestSplitManifestHostilePartNames(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	shardDir := filepath.Join(dir, "shards")
+	os.Mkdir(shardDir, 0755)
+	filePath := filepath.Join(shardDir, "data.bin")
+	writeTestFile(t, filePath, 1000)
+	manifestPath := filePath + mft.ManifestSuffix
+	manifest, err := utils.SplitFileErasure(filePath, 2, 1, manifestPath)
+	if err != nil {
+		t.Fatalf("Error splitting file: %v", err)
+	}
+
+	// A missing shard named outside the manifest's directory must not be rebuilt there.
+	for _, name := range []string{"../victim", filepath.Join(dir, "victim"), "sub/victim", `sub\victim`, ".."} {
+		manifest.Parts[1].Name = name
+		data, _ := json.Marshal(manifest)
+		os.WriteFile(manifestPath, data, 0644)
+		if _, err := utils.RepairShards(manifestPath); !errors.Is(err, mft.ErrUnsafePartName) {
+			t.Errorf("Expected ErrUnsafePartName for %q, got: %v", name, err)
+		}
+		if err := utils.MergeFromManifest(manifestPath, filepath.Join(dir, "merged.bin")); !errors.Is(err, mft.ErrUnsafePartName) {
+			t.Errorf("Expected ErrUnsafePartName from merge for %q, got: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "victim")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written outside the manifest's directory, got: %v", err)
+	}
+
+	// The writers refuse to produce such names too.
+	if _, err := utils.SplitFileWithManifest(filePath, 500, filepath.Join(dir, "data.manifest.json")); !errors.Is(err, mft.ErrUnsafePartName) {
+		t.Errorf("Expected ErrUnsafePartName for a manifest outside the parts' directory, got: %v", err)
+	}
+}
+
+func TestSplitRecords(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "orders.csv")
+	content := "id,region,note\n" +
+		"1,east,plain\n" +
+		"2,east,\"two\nlines\"\n" +
+		"3,west,\"a, b\"\n" +
+		"4,west,plain\n" +
+		"5,north,last"
+	os.WriteFile(csvPath, []byte(content), 0644)
+
+	parts, err := utils.SplitRecords(csvPath, mft.RecordSplitOptions{Format: mft.RecordCSV, Header: true, MaxRecords: 2})
+	if err != nil {
+		t.Fatalf("Error splitting records: %v", err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("Expected 3 chunks, got: %v", parts)
+	}
+	second, _ := os.ReadFile(parts[1])
+	if string(second) != "id,region,note\n3,west,\"a, b\"\n4,west,plain\n" {
+		t.Errorf("Expected the header and records 3 and 4, got: %q", second)
+	}
+	first, _ := os.ReadFile(parts[0])
+	if string(first) != "id,region,note\n1,east,plain\n2,east,\"two\nlines\"\n" {
+		t.Errorf("Expected the quoted line break to stay in its record, got: %q", first)
+	}
+
+	mergedPath := filepath.Join(dir, "merged.csv")
+	if err := utils.MergeRecords(parts, mergedPath, mft.RecordSplitOptions{Format: mft.RecordCSV, Header: true}); err != nil {
+		t.Fatalf("Error merging records: %v", err)
+	}
+	if merged, _ := os.ReadFile(mergedPath); string(merged) != content {
+		t.Errorf("Expected merged file to match the original, got: %q", merged)
+	}
+
+	parts, err = utils.SplitRecords(csvPath, mft.RecordSplitOptions{Format: mft.RecordCSV, Header: true, SplitByKey: true, KeyColumn: 1})
+	if err != nil || len(parts) != 3 {
+		t.Fatalf("Expected one chunk per region, got: %v, %v", parts, err)
+	}
+	third, _ := os.ReadFile(parts[2])
+	if string(third) != "id,region,note\n5,north,last" {
+		t.Errorf("Expected the north chunk, got: %q", third)
+	}
+
+	fixedPath := filepath.Join(dir, "fixed.txt")
+	os.WriteFile(fixedPath, []byte("AAA0001\nAAA0002\nBBB0003\nBBB0004\nBBB0005\n"), 0644)
+	parts, err = utils.SplitRecords(fixedPath, mft.RecordSplitOptions{MaxBytes: 20})
+	if err != nil || len(parts) != 3 {
+		t.Fatalf("Expected 3 chunks of at most 20 bytes, got: %v, %v", parts, err)
+	}
+	if chunk, _ := os.ReadFile(parts[0]); string(chunk) != "AAA0001\nAAA0002\n" {
+		t.Errorf("Expected chunks to end on a record boundary, got: %q", chunk)
+	}
+	parts, err = utils.SplitRecords(fixedPath, mft.RecordSplitOptions{SplitByKey: true, KeyLength: 3})
+	if err != nil || len(parts) != 2 {
+		t.Fatalf("Expected one chunk per key, got: %v, %v", parts, err)
+	}
+	if chunk, _ := os.ReadFile(parts[1]); string(chunk) != "BBB0003\nBBB0004\nBBB0005\n" {
+		t.Errorf("Expected the BBB records, got: %q", chunk)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/madhu72/mftkit/mft"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPKI holds PEM files for a self-signed CA and the certificates it issued.
+type testPKI struct {
+	caFile            string
+	serverCert        string
+	serverKey         string
+	serverFingerprint string
+	clientCert        string
+	clientKey         string
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mftkit test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Error creating CA certificate: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	pki := testPKI{caFile: filepath.Join(dir, "ca.pem")}
+	writePEM(t, pki.caFile, "CERTIFICATE", caDER)
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string, *x509.Certificate) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Error generating key: %v", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("Error creating certificate: %v", err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatalf("Error marshaling key: %v", err)
+		}
+		certFile := filepath.Join(dir, name+".pem")
+		keyFile := filepath.Join(dir, name+".key")
+		writePEM(t, certFile, "CERTIFICATE", der)
+		writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+		cert, _ := x509.ParseCertificate(der)
+		return certFile, keyFile, cert
+	}
+
+	var serverCert *x509.Certificate
+	pki.serverCert, pki.serverKey, serverCert = issue("server", 2, x509.ExtKeyUsageServerAuth)
+	pki.clientCert, pki.clientKey, _ = issue("client", 3, x509.ExtKeyUsageClientAuth)
+	pki.serverFingerprint = mft.CertificateFingerprint(serverCert)
+	return pki
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Error writing %s: %v", path, err)
+	}
+}
+
+func startTLSServer(t *testing.T, options *mft.TLSOptions) (*mft.Server, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+
+	server := mft.NewServer(t.TempDir())
+	server.TLS = options
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return server, listener.Addr().String()
+}
+
+func TestTLSUploadDownload(t *testing.T) {
+	pki := newTestPKI(t)
+	_, addr := startTLSServer(t, &mft.TLSOptions{CertFile: pki.serverCert, KeyFile: pki.serverKey})
+
+	utils := mft.NewMFT()
+	if err := utils.SetTLSOptions(&mft.TLSOptions{CAFile: pki.caFile}); err != nil {
+		t.Fatalf("Error setting TLS options: %v", err)
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.bin")
+	writeTestFile(t, source, 256<<10)
+
+	if err := utils.UploadFile(addr, source, "secure.bin"); err != nil {
+		t.Fatalf("Error uploading over TLS: %v", err)
+	}
+	destination := filepath.Join(dir, "downloaded.bin")
+	if err := utils.DownloadFile(addr, "secure.bin", destination); err != nil {
+		t.Fatalf("Error downloading over TLS: %v", err)
+	}
+	assertSameChecksum(t, utils, source, destination)
+
+	plain := mft.NewMFT()
+	if err := plain.UploadFile(addr, source, "plain.bin"); err == nil {
+		t.Errorf("Expected a plaintext upload to a TLS server to fail")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	_, addr := startTLSServer(t, &mft.TLSOptions{
+		CAFile:     pki.caFile,
+		CertFile:   pki.serverCert,
+		KeyFile:    pki.serverKey,
+		MinVersion: tls.VersionTLS13,
+	})
+
+	source := filepath.Join(t.TempDir(), "source.txt")
+	writeTestFile(t, source, 1024)
+
+	anonymous := mft.NewMFT()
+	anonymous.SetTLSOptions(&mft.TLSOptions{CAFile: pki.caFile})
+	if err := anonymous.UploadFile(addr, source, "anonymous.txt"); err == nil {
+		t.Errorf("Expected an upload without a client certificate to fail")
+	}
+
+	authenticated := mft.NewMFT()
+	err := authenticated.SetTLSOptions(&mft.TLSOptions{
+		CAFile:   pki.caFile,
+		CertFile: pki.clientCert,
+		KeyFile:  pki.clientKey,
+	})
+	if err != nil {
+		t.Fatalf("Error setting TLS options: %v", err)
+	}
+	if err := authenticated.UploadFile(addr, source, "authenticated.txt"); err != nil {
+		t.Errorf("Error uploading with a client certificate: %v", err)
+	}
+}
+
+func TestPinnedServerFingerprint(t *testing.T) {
+	pki := newTestPKI(t)
+	_, addr := startTLSServer(t, &mft.TLSOptions{CertFile: pki.serverCert, KeyFile: pki.serverKey})
+
+	source := filepath.Join(t.TempDir(), "source.txt")
+	writeTestFile(t, source, 1024)
+
+	pinned := mft.NewMFT()
+	pinned.SetTLSOptions(&mft.TLSOptions{PinnedFingerprints: []string{pki.serverFingerprint}})
+	if err := pinned.UploadFile(addr, source, "pinned.txt"); err != nil {
+		t.Errorf("Error uploading to a pinned server: %v", err)
+	}
+
+	wrongPin := mft.NewMFT()
+	wrongPin.SetTLSOptions(&mft.TLSOptions{PinnedFingerprints: []string{"00112233"}})
+	if err := wrongPin.UploadFile(addr, source, "wrong.txt"); err == nil {
+		t.Errorf("Expected an upload to an unpinned server certificate to fail")
+	}
+}
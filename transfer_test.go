@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/madhu72/mftkit/mft"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServer runs an mft.Server on a loopback listener rooted at a temporary directory.
+func startServer(t *testing.T) (*mft.Server, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+
+	server := mft.NewServer(t.TempDir())
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return server, listener.Addr().String()
+}
+
+func writeTestFile(t *testing.T, path string, size int) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Error writing test file: %v", err)
+	}
+	return data
+}
+
+func TestUploadDownloadRoundTrip(t *testing.T) {
+	utils := mft.NewMFT()
+	server, addr := startServer(t)
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "source.bin")
+	data := writeTestFile(t, source, 1<<20+123)
+
+	if err := utils.UploadFile(addr, source, "inbound/partner/report.bin"); err != nil {
+		t.Fatalf("Error uploading file: %v", err)
+	}
+
+	stored, err := os.ReadFile(filepath.Join(server.Root, "inbound", "partner", "report.bin"))
+	if err != nil {
+		t.Fatalf("Error reading stored file: %v", err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Errorf("Stored file does not match uploaded file")
+	}
+
+	destination := filepath.Join(dir, "downloaded.bin")
+	if err := utils.DownloadFile(addr, "inbound/partner/report.bin", destination); err != nil {
+		t.Fatalf("Error downloading file: %v", err)
+	}
+
+	downloaded, err := os.ReadFile(destination)
+	if err != nil {
+		t.Fatalf("Error reading downloaded file: %v", err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Errorf("Downloaded file does not match uploaded file")
+	}
+}
+
+func TestUploadStaysInsideRoot(t *testing.T) {
+	utils := mft.NewMFT()
+	server, addr := startServer(t)
+
+	source := filepath.Join(t.TempDir(), "source.txt")
+	writeTestFile(t, source, 64)
+
+	if err := utils.UploadFile(addr, source, "../../escaped.txt"); err != nil {
+		t.Fatalf("Error uploading file: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(server.Root, "escaped.txt")); err != nil {
+		t.Errorf("Expected file to be stored inside the server root: %v", err)
+	}
+}
+
+func TestDownloadMissingFile(t *testing.T) {
+	utils := mft.NewMFT()
+	_, addr := startServer(t)
+
+	destination := filepath.Join(t.TempDir(), "missing.txt")
+	if err := utils.DownloadFile(addr, "does/not/exist.txt", destination); err == nil {
+		t.Errorf("Expected an error downloading a missing file")
+	}
+	if _, err := os.Stat(destination); !os.IsNotExist(err) {
+		t.Errorf("Expected no destination file for a failed download")
+	}
+}
+
+func TestServerRejectsTransferStateFiles(t *testing.T) {
+	utils := mft.NewMFT()
+	server, addr := startServer(t)
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "source.txt")
+	writeTestFile(t, source, 64)
+	if err := utils.UploadFile(addr, source, "inbound/report.bin.mftpart"); err == nil {
+		t.Errorf("Expected an error uploading a partial file")
+	}
+	if _, err := os.Stat(filepath.Join(server.Root, "inbound", "report.bin.mftpart")); !os.IsNotExist(err) {
+		t.Errorf("Expected no partial file to be stored, got: %v", err)
+	}
+
+	// The checkpoint of another client's upload must not be readable.
+	checkpoint := filepath.Join(server.Root, "report.bin.mftckpt")
+	os.WriteFile(checkpoint, []byte("{}"), 0644)
+	if err := utils.DownloadFile(addr, "report.bin.mftckpt", filepath.Join(dir, "checkpoint")); err == nil {
+		t.Errorf("Expected an error downloading a checkpoint file")
+	}
+	if err := utils.DeleteRemoteFile("tcp://" + addr + "/report.bin.mftckpt"); err == nil {
+		t.Errorf("Expected an error deleting a checkpoint file")
+	}
+	if _, err := os.Stat(checkpoint); err != nil {
+		t.Errorf("Expected the checkpoint file to be kept, got: %v", err)
+	}
+}
+
+func TestServerRejectsLargeRequestFrame(t *testing.T) {
+	_, addr := startServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error dialing server: %v", err)
+	}
+	defer conn.Close()
+
+	// A request frame announcing 1 MiB is refused before its payload is read.
+	frame := binary.BigEndian.AppendUint32([]byte("MFT1"), 1<<20)
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("Error writing frame: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the server to close the connection, got %d bytes (%v)", n, err)
+	}
+}
+
+// flakyProxy forwards loopback connections to a target and cuts every connection
+// once limit bytes have been forwarded in one direction. A zero limit never cuts.
+type flakyProxy struct {
+	addr      string
+	target    string
+	limit     int64
+	forwarded atomic.Int64
+}
+
+func startProxy(t *testing.T, target string, limit int64) *flakyProxy {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	p := &flakyProxy{addr: listener.Addr().String(), target: target, limit: limit}
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			go p.pipe(upstream, client)
+			go p.pipe(client, upstream)
+		}
+	}()
+	return p
+}
+
+func (p *flakyProxy) pipe(dst, src net.Conn) {
+	var reader io.Reader = src
+	if p.limit > 0 {
+		reader = io.LimitReader(src, p.limit)
+	}
+	n, _ := io.Copy(dst, reader)
+	p.forwarded.Add(n)
+	dst.Close()
+	src.Close()
+}
+
+func TestResumeInterruptedUpload(t *testing.T) {
+	utils := mft.NewMFT()
+	server, addr := startServer(t)
+
+	source := filepath.Join(t.TempDir(), "big.bin")
+	writeTestFile(t, source, 3<<20)
+	target := filepath.Join(server.Root, "big.bin")
+
+	flaky := startProxy(t, addr, 1<<20)
+	if err := utils.UploadFile(flaky.addr, source, "big.bin"); err == nil {
+		t.Fatalf("Expected the interrupted upload to fail")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		checkpoint, err := utils.LoadTransferCheckpoint(target)
+		if err == nil && checkpoint.Offset > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a checkpoint for the interrupted upload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	counting := startProxy(t, addr, 0)
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = utils.UploadFile(counting.addr, source, "big.bin"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Error resuming upload: %v", err)
+	}
+	if counting.forwarded.Load() >= 3<<20 {
+		t.Errorf("Expected the resumed upload to skip data already received, sent %d bytes", counting.forwarded.Load())
+	}
+
+	assertSameChecksum(t, utils, source, target)
+	if _, err := utils.LoadTransferCheckpoint(target); !os.IsNotExist(err) {
+		t.Errorf("Expected the checkpoint to be removed after completion")
+	}
+}
+
+func TestResumeInterruptedDownload(t *testing.T) {
+	utils := mft.NewMFT()
+	server, addr := startServer(t)
+
+	source := filepath.Join(server.Root, "big.bin")
+	writeTestFile(t, source, 3<<20)
+	destination := filepath.Join(t.TempDir(), "big.bin")
+
+	flaky := startProxy(t, addr, 1<<20)
+	if err := utils.DownloadFile(flaky.addr, "big.bin", destination); err == nil {
+		t.Fatalf("Expected the interrupted download to fail")
+	}
+
+	checkpoint, err := utils.LoadTransferCheckpoint(destination)
+	if err != nil || checkpoint.Offset == 0 {
+		t.Fatalf("Expected a checkpoint for the interrupted download: %v", err)
+	}
+
+	counting := startProxy(t, addr, 0)
+	if err := utils.DownloadFile(counting.addr, "big.bin", destination); err != nil {
+		t.Fatalf("Error resuming download: %v", err)
+	}
+	if counting.forwarded.Load() >= 3<<20 {
+		t.Errorf("Expected the resumed download to skip data already received, got %d bytes", counting.forwarded.Load())
+	}
+
+	assertSameChecksum(t, utils, source, destination)
+}
+
+func assertSameChecksum(t *testing.T, utils *mft.MFT, expectedPath, actualPath string) {
+	t.Helper()
+
+	expected, err := utils.CalculateChecksum(expectedPath)
+	if err != nil {
+		t.Fatalf("Error calculating checksum: %v", err)
+	}
+	valid, err := utils.ValidateFile(actualPath, expected)
+	if err != nil {
+		t.Fatalf("Error validating file: %v", err)
+	}
+	if !valid {
+		t.Errorf("Checksum of %s does not match %s", actualPath, expectedPath)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"github.com/madhu72/mftkit/mft"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileTransport(t *testing.T) {
+	utils := mft.NewMFT()
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "source.txt")
+	writeTestFile(t, source, 2048)
+	target := "file://" + filepath.ToSlash(filepath.Join(dir, "outbound", "copy.txt"))
+
+	if err := utils.PutFile(source, target); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+	metadata, err := utils.GetRemoteFileMetadata(target)
+	if err != nil {
+		t.Fatalf("Error getting metadata: %v", err)
+	}
+	if metadata.Size != 2048 {
+		t.Errorf("Expected size 2048, got: %d", metadata.Size)
+	}
+
+	files, err := utils.ListRemoteFiles("file://" + filepath.ToSlash(filepath.Join(dir, "outbound")))
+	if err != nil || len(files) != 1 || files[0] != "copy.txt" {
+		t.Errorf("Expected [copy.txt], got: %v (%v)", files, err)
+	}
+
+	if err := utils.DeleteRemoteFile(target); err != nil {
+		t.Fatalf("Error deleting file: %v", err)
+	}
+	if _, err := utils.GetRemoteFileMetadata(target); err == nil {
+		t.Errorf("Expected the deleted file to be gone")
+	}
+}
+
+func TestTCPTransportAndSchedule(t *testing.T) {
+	utils := mft.NewMFT()
+	server, addr := startServer(t)
+	base := "tcp://" + addr
+
+	source := filepath.Join(t.TempDir(), "source.bin")
+	writeTestFile(t, source, 64<<10)
+
+	if err := utils.PutFile(source, base+"/inbound/direct.bin"); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+	if err := utils.UploadFile(base, source, "inbound/legacy.bin"); err != nil {
+		t.Fatalf("Error uploading through a URL: %v", err)
+	}
+
+	schedule := mft.Schedule{
+		Time:        time.Now().Add(10 * time.Millisecond),
+		FilePath:    source,
+		Destination: base + "/inbound/scheduled.bin",
+	}
+	if err := utils.ScheduleFileTransfer(schedule); err != nil {
+		t.Fatalf("Error scheduling transfer: %v", err)
+	}
+
+	scheduled := filepath.Join(server.Root, "inbound", "scheduled.bin")
+	deadline := time.Now().Add(5 * time.Second)
+	for !utils.CheckFileExists(scheduled) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Scheduled transfer did not arrive")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	files, err := utils.ListRemoteFiles(base + "/inbound")
+	if err != nil {
+		t.Fatalf("Error listing files: %v", err)
+	}
+	if len(files) != 3 {
+		t.Errorf("Expected 3 files, got: %v", files)
+	}
+
+	if err := utils.DeleteRemoteFile(base + "/inbound/direct.bin"); err != nil {
+		t.Fatalf("Error deleting file: %v", err)
+	}
+	if _, err := utils.GetRemoteFileMetadata(base + "/inbound/direct.bin"); err == nil {
+		t.Errorf("Expected the deleted file to be gone")
+	}
+}
+
+func TestSyncDirectoriesToURL(t *testing.T) {
+	utils := mft.NewMFT()
+	server, addr := startServer(t)
+
+	sourceDir := t.TempDir()
+	os.MkdirAll(filepath.Join(sourceDir, "nested"), os.ModePerm)
+	writeTestFile(t, filepath.Join(sourceDir, "a.txt"), 10)
+	writeTestFile(t, filepath.Join(sourceDir, "nested", "b.txt"), 20)
+
+	if err := utils.SyncDirectories(sourceDir, "tcp://"+addr+"/mirror"); err != nil {
+		t.Fatalf("Error syncing directories: %v", err)
+	}
+	for _, name := range []string{"a.txt", filepath.Join("nested", "b.txt")} {
+		if !utils.CheckFileExists(filepath.Join(server.Root, "mirror", name)) {
+			t.Errorf("Expected %s to be synced", name)
+		}
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	utils := mft.NewMFT()
+	stored := make(map[string][]byte)
+	var mu sync.Mutex
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		mu.Lock()
+		stored[header.Filename] = data
+		mu.Unlock()
+	})
+	handler.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		data, ok := stored[r.URL.Query().Get("file")]
+		mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	writeTestFile(t, source, 4096)
+
+	if err := utils.PutFile(source, server.URL+"/upload?file=report.txt"); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+	destination := filepath.Join(dir, "downloaded.txt")
+	if err := utils.GetFile(server.URL+"/download?file=report.txt", destination); err != nil {
+		t.Fatalf("Error getting file: %v", err)
+	}
+	assertSameChecksum(t, utils, source, destination)
+
+	if _, err := utils.ListRemoteFiles(server.URL + "/"); !errors.Is(err, mft.ErrUnsupportedOperation) {
+		t.Errorf("Expected ErrUnsupportedOperation, got: %v", err)
+	}
+}
+
+func TestCustomProtocolTransport(t *testing.T) {
+	utils := mft.NewMFT()
+	store := make(map[string][]byte)
+
+	err := utils.AddCustomProtocolHandler("memory", func(request mft.Request) (mft.Response, error) {
+		switch request.Operation {
+		case mft.OpUpload:
+			store[request.Path] = request.Data
+			return mft.Response{Status: "stored"}, nil
+		case mft.OpDownload:
+			data, ok := store[request.Path]
+			if !ok {
+				return mft.Response{}, os.ErrNotExist
+			}
+			return mft.Response{Status: "found", Data: data}, nil
+		}
+		return mft.Response{}, mft.ErrUnsupportedOperation
+	})
+	if err != nil {
+		t.Fatalf("Error adding protocol handler: %v", err)
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	writeTestFile(t, source, 300)
+
+	if err := utils.PutFile(source, "memory://bucket/a/source.txt"); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+	destination := filepath.Join(dir, "downloaded.txt")
+	if err := utils.GetFile("memory://bucket/a/source.txt", destination); err != nil {
+		t.Fatalf("Error getting file: %v", err)
+	}
+	assertSameChecksum(t, utils, source, destination)
+
+	response, err := utils.HandleCustomProtocolRequest(mft.Request{Protocol: "memory", Operation: mft.OpDownload, Path: "/a/source.txt"})
+	if err != nil || len(response.Data) != 300 {
+		t.Errorf("Expected the handler to serve the stored file, got %d bytes (%v)", len(response.Data), err)
+	}
+}
+
+func TestSFTPTransport(t *testing.T) {
+	utils := mft.NewMFT()
+	utils.SetSFTPConfig(mft.SFTPConfig{InsecureIgnoreHostKey: true})
+	server, addr := startSFTPServer(t, newSigner(t).PublicKey())
+
+	source := filepath.Join(t.TempDir(), "source.txt")
+	writeTestFile(t, source, 1000)
+
+	if err := utils.PutFile(source, "sftp://partner:s3cret@"+addr+"/drop/source.txt"); err != nil {
+		t.Fatalf("Error putting file over SFTP: %v", err)
+	}
+	assertSameChecksum(t, utils, source, filepath.Join(server.Root, "drop", "source.txt"))
+}
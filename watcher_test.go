@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/madhu72/mftkit/mft"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// eventRecorder collects watcher events for a test.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []mft.FileEvent
+}
+
+func (r *eventRecorder) record(event mft.FileEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// waitFor waits until an event for name with op has been recorded.
+func (r *eventRecorder) waitFor(t *testing.T, name string, op fsnotify.Op) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(r.matching(name, op)) > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected a %v event for %s, got: %v", op, name, r.snapshot())
+}
+
+func (r *eventRecorder) matching(name string, op fsnotify.Op) []mft.FileEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matches []mft.FileEvent
+	for _, event := range r.events {
+		if event.Name == name && event.Op.Has(op) {
+			matches = append(matches, event)
+		}
+	}
+	return matches
+}
+
+func (r *eventRecorder) snapshot() []mft.FileEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]mft.FileEvent(nil), r.events...)
+}
+
+func TestWatchTree(t *testing.T) {
+	utils := mft.NewMFT()
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "existing"), os.ModePerm)
+	os.MkdirAll(filepath.Join(root, "tmp"), os.ModePerm)
+
+	recorder := &eventRecorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- utils.WatchTree(ctx, root, mft.WatchOptions{
+			Include:  []string{"*.csv"},
+			Exclude:  []string{"tmp"},
+			Debounce: 100 * time.Millisecond,
+		}, recorder.record)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	existingPath := filepath.Join(root, "existing", "a.csv")
+	file, err := os.Create(existingPath)
+	if err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		file.WriteString("row\n")
+		time.Sleep(2 * time.Millisecond)
+	}
+	file.Close()
+	recorder.waitFor(t, existingPath, fsnotify.Write)
+
+	// New subdirectories are watched, including files created right away.
+	nestedPath := filepath.Join(root, "new", "deeper", "b.csv")
+	os.MkdirAll(filepath.Dir(nestedPath), os.ModePerm)
+	os.WriteFile(nestedPath, []byte("x"), 0644)
+	recorder.waitFor(t, nestedPath, fsnotify.Create)
+	laterPath := filepath.Join(root, "new", "deeper", "c.csv")
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(laterPath, []byte("x"), 0644)
+	recorder.waitFor(t, laterPath, fsnotify.Create)
+
+	os.WriteFile(filepath.Join(root, "tmp", "skip.csv"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(root, "existing", "skip.txt"), []byte("x"), 0644)
+	os.Remove(existingPath)
+	recorder.waitFor(t, existingPath, fsnotify.Remove)
+
+	if writes := recorder.matching(existingPath, fsnotify.Write); len(writes) != 1 {
+		t.Errorf("Expected the writes to be coalesced into one event, got: %v", writes)
+	}
+	for _, event := range recorder.snapshot() {
+		if filepath.Ext(event.Name) != ".csv" || filepath.Base(filepath.Dir(event.Name)) == "tmp" {
+			t.Errorf("Expected filtered paths to produce no events, got: %v", event)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a nil error on cancellation, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected WatchTree to return after cancellation")
+	}
+}
+
+// watchArrivals runs WatchArrivals on a new directory and returns it with a
+// channel of ready files and a function that stops the watcher.
+func watchArrivals(t *testing.T, options mft.ArrivalOptions) (string, chan string, func()) {
+	t.Helper()
+	utils := mft.NewMFT()
+	root := t.TempDir()
+	ready := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := utils.WatchArrivals(ctx, root, options, func(filePath string) { ready <- filePath }); err != nil {
+			t.Errorf("Error watching arrivals: %v", err)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	return root, ready, func() {
+		cancel()
+		<-done
+	}
+}
+
+func expectReady(t *testing.T, ready chan string, expected string, after time.Duration) {
+	t.Helper()
+	select {
+	case filePath := <-ready:
+		if filePath != expected {
+			t.Errorf("Expected %s to be ready, got: %s", expected, filePath)
+		}
+	case <-time.After(after):
+		t.Fatalf("Expected %s to be ready", expected)
+	}
+}
+
+func expectNotReady(t *testing.T, ready chan string, wait time.Duration) {
+	t.Helper()
+	select {
+	case filePath := <-ready:
+		t.Fatalf("Expected no file to be ready yet, got: %s", filePath)
+	case <-time.After(wait):
+	}
+}
+
+func TestWatchArrivalsStableSize(t *testing.T) {
+	root, ready, stop := watchArrivals(t, mft.ArrivalOptions{StableFor: 300 * time.Millisecond, PollInterval: 50 * time.Millisecond})
+	defer stop()
+
+	filePath := filepath.Join(root, "upload.csv")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		file.WriteString("row\n")
+		time.Sleep(100 * time.Millisecond)
+	}
+	expectNotReady(t, ready, 100*time.Millisecond)
+	file.Close()
+	expectReady(t, ready, filePath, 3*time.Second)
+	expectNotReady(t, ready, 500*time.Millisecond)
+}
+
+func TestWatchArrivalsTriggerFile(t *testing.T) {
+	root, ready, stop := watchArrivals(t, mft.ArrivalOptions{Strategy: mft.ReadyTriggerFile})
+	defer stop()
+
+	dataPath := filepath.Join(root, "report.csv")
+	os.WriteFile(dataPath, []byte("data"), 0644)
+	expectNotReady(t, ready, 200*time.Millisecond)
+	os.WriteFile(filepath.Join(root, "report.done"), nil, 0644)
+	expectReady(t, ready, dataPath, 3*time.Second)
+
+	otherPath := filepath.Join(root, "other.csv")
+	os.WriteFile(otherPath, []byte("data"), 0644)
+	os.WriteFile(otherPath+".ok", nil, 0644)
+	expectReady(t, ready, otherPath, 3*time.Second)
+
+	// The trigger may also arrive before the data file is renamed into place.
+	latePath := filepath.Join(root, "late.csv")
+	os.WriteFile(latePath+".done", nil, 0644)
+	os.WriteFile(filepath.Join(root, "late.csv.part"), []byte("data"), 0644)
+	expectNotReady(t, ready, 200*time.Millisecond)
+	os.Rename(filepath.Join(root, "late.csv.part"), latePath)
+	expectReady(t, ready, latePath, 3*time.Second)
+	expectNotReady(t, ready, 200*time.Millisecond)
+}
+
+func TestWatchArrivalsRename(t *testing.T) {
+	root, ready, stop := watchArrivals(t, mft.ArrivalOptions{Strategy: mft.ReadyRename})
+	defer stop()
+
+	finalPath := filepath.Join(root, "report.csv")
+	os.WriteFile(finalPath+".filepart", []byte("data"), 0644)
+	expectNotReady(t, ready, 200*time.Millisecond)
+	os.Rename(finalPath+".filepart", finalPath)
+	expectReady(t, ready, finalPath, 3*time.Second)
+}
+
+func TestWatchArrivalsLockProbe(t *testing.T) {
+	root, ready, stop := watchArrivals(t, mft.ArrivalOptions{Strategy: mft.ReadyLockProbe, PollInterval: 50 * time.Millisecond})
+	defer stop()
+
+	filePath := filepath.Join(root, "locked.csv")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	file.WriteString("data")
+	expectNotReady(t, ready, 300*time.Millisecond)
+	file.Close()
+	expectReady(t, ready, filePath, 3*time.Second)
+}
+
+// moveTestFile writes a test file next to path and renames it into place, so a
+// scan never sees it half written.
+func moveTestFile(t *testing.T, path string, size int) {
+	t.Helper()
+	writeTestFile(t, path+".tmp", size)
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatalf("Error renaming test file: %v", err)
+	}
+}
+
+func TestPollTree(t *testing.T) {
+	utils := mft.NewMFT()
+	root := t.TempDir()
+	snapshotPath := filepath.Join(root, "snapshot.json")
+	existing := filepath.Join(root, "existing.csv")
+	writeTestFile(t, existing, 100)
+	os.MkdirAll(filepath.Join(root, "sub"), 0755)
+
+	poll := func(recorder *eventRecorder) func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			options := mft.PollOptions{Interval: 50 * time.Millisecond, SnapshotPath: snapshotPath, Exclude: []string{"*.tmp"}}
+			if err := utils.PollTree(ctx, root, options, recorder.record); err != nil {
+				t.Errorf("Error polling tree: %v", err)
+			}
+		}()
+		return func() {
+			cancel()
+			<-done
+		}
+	}
+
+	recorder := &eventRecorder{}
+	stop := poll(recorder)
+	time.Sleep(100 * time.Millisecond)
+	created := filepath.Join(root, "sub", "created.csv")
+	moveTestFile(t, created, 10)
+	recorder.waitFor(t, created, fsnotify.Create)
+	file, err := os.OpenFile(existing, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	file.Write([]byte("appended"))
+	file.Close()
+	recorder.waitFor(t, existing, fsnotify.Write)
+	os.Remove(created)
+	recorder.waitFor(t, created, fsnotify.Remove)
+	writeTestFile(t, filepath.Join(root, "skipped.tmp"), 10)
+	time.Sleep(200 * time.Millisecond)
+	stop()
+	if events := recorder.snapshot(); len(events) != 3 {
+		t.Errorf("Expected 3 events, got: %v", events)
+	}
+
+	// Only the changes made while the scanner was stopped are reported.
+	arrived := filepath.Join(root, "arrived.csv")
+	writeTestFile(t, arrived, 10)
+	recorder = &eventRecorder{}
+	stop = poll(recorder)
+	recorder.waitFor(t, arrived, fsnotify.Create)
+	time.Sleep(200 * time.Millisecond)
+	stop()
+	if events := recorder.snapshot(); len(events) != 1 {
+		t.Errorf("Expected only the new file after a restart, got: %v", events)
+	}
+}
+
+func TestPollTreeUnreadableDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("directory permissions do not apply to root")
+	}
+	utils := mft.NewMFT()
+	root := t.TempDir()
+	for _, dir := range []string{"ok", "denied", "revoked"} {
+		os.MkdirAll(filepath.Join(root, dir), 0755)
+		writeTestFile(t, filepath.Join(root, dir, "a.csv"), 10)
+	}
+	// One directory cannot be read from the first scan, another becomes
+	// unreadable later.
+	os.Chmod(filepath.Join(root, "denied"), 0)
+	defer os.Chmod(filepath.Join(root, "denied"), 0755)
+
+	recorder := &eventRecorder{}
+	var errorCount atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		options := mft.PollOptions{Interval: 50 * time.Millisecond, Exclude: []string{"*.tmp"}, OnError: func(err error) { errorCount.Add(1) }}
+		done <- utils.PollTree(ctx, root, options, recorder.record)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	os.Chmod(filepath.Join(root, "revoked"), 0)
+	defer os.Chmod(filepath.Join(root, "revoked"), 0755)
+
+	created := filepath.Join(root, "ok", "created.csv")
+	moveTestFile(t, created, 10)
+	recorder.waitFor(t, created, fsnotify.Create)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected unreadable subdirectories not to stop the scanner, got: %v", err)
+	}
+	if errorCount.Load() == 0 {
+		t.Errorf("Expected the unreadable directories to be reported to OnError")
+	}
+	if events := recorder.matching(filepath.Join(root, "revoked", "a.csv"), fsnotify.Remove); len(events) > 0 {
+		t.Errorf("Expected files in an unreadable directory not to be reported as removed, got: %v", events)
+	}
+}